@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const PEOPLE_FOLDER_TYPE = "peopleFolder"
+
+// One entry of a show's cast block list
+type CastMember struct {
+	Character string `json:"character"` // Found in umbraco: ~content.showCast.$invariant.contentData.character   found in TVMaze: character.name
+	Person    string `json:"person"`    // UDI of the Person node. Found in umbraco: ~content.showCast.$invariant.contentData.person
+}
+
+// A TVMaze person, found in TVMaze: /shows/{id}/cast person
+type mazePerson struct {
+	Id          int
+	Name        string
+	Country     string
+	Birthday    string // YYYY-MM-DD
+	Deathday    string
+	Gender      string
+	ImageSource string // image.medium or image.original, see Configs.ImageVariant
+}
+
+// Person nodes, keyed by TVMaze person ID. People play in many shows, so each gets one node shared by all of them
+var peopleNodes = &ContentCollection{
+	FolderName: &config.PeopleFolder,
+	FolderType: PEOPLE_FOLDER_TYPE,
+	NodeKey: func(node gjson.Result) string {
+		if node.Get("contentTypeAlias").String() != "person" {
+			return ""
+		}
+		return node.Get("personId.$invariant").String()
+	},
+}
+
+// Fetches the show's cast, creating the Person nodes that don't exist yet
+func castFor(show Show) ([]CastMember, error) {
+	data, err := fetchMazeRetry(fmt.Sprintf("%s%d/cast", config.MazeShowURL, show.Id))
+	if err != nil {
+		return nil, err
+	}
+	cast := []CastMember{}
+	for _, entry := range gjson.ParseBytes(data).Array() {
+		id, err := personFor(parseMazePerson(entry.Get("person")))
+		if err != nil {
+			return nil, err
+		}
+		cast = append(cast, CastMember{Character: entry.Get("character.name").String(), Person: umbDocumentUdi(id)})
+	}
+	return cast, nil
+}
+
+func parseMazePerson(person gjson.Result) mazePerson {
+	return mazePerson{
+		Id:          int(person.Get("id").Int()),
+		Name:        person.Get("name").String(),
+		Country:     person.Get("country.name").String(),
+		Birthday:    person.Get("birthday").String(),
+		Deathday:    person.Get("deathday").String(),
+		Gender:      person.Get("gender").String(),
+		ImageSource: person.Get("image." + config.ImageVariant).String(),
+	}
+}
+
+// _id of the person's node. New people get their headshot uploaded first, a failed upload leaves the picker empty
+func personFor(person mazePerson) (string, error) {
+	return peopleNodes.Node(strconv.Itoa(person.Id), func() (map[string]interface{}, error) {
+		image := ""
+		if person.ImageSource != "" {
+			uploaded, err := uploadHeadshot(person)
+			if err != nil {
+				fmt.Printf("Failed to upload the headshot of person %d: %v\n", person.Id, err)
+			} else {
+				image = uploaded.Key
+			}
+		}
+		return map[string]interface{}{
+			"contentTypeAlias":  "person",
+			"name":              map[string]string{LANGUAGE: person.Name},
+			"personId":          invariant(person.Id),
+			"personCountry":     invariant(person.Country),
+			"personBirthday":    invariant(person.Birthday),
+			"personDeathday":    invariant(person.Deathday),
+			"personGender":      invariant(person.Gender),
+			"personImage":       invariant(mediaPicker(image)),
+			"personImageSource": invariant(person.ImageSource),
+		}, nil
+	})
+}
+
+// Uploads through createUmbImage like the show images. Headshots aren't tied to one show, so with
+// --media-folders they go in a folder of their own below the media folder root
+func uploadHeadshot(person mazePerson) (uploadedImage, error) {
+	folderId := ""
+	if _, exists := mediaFolderNames[config.MediaFolders]; exists {
+		var err error
+		if folderId, err = mediaFolders.Path(config.MediaFolderRoot, config.PeopleFolder); err != nil {
+			fmt.Printf("Failed to get media folder for person %d, using the media root: %v\n", person.Id, err)
+		}
+	}
+	properties := mediaProperties("Photo", person.Name, 0)
+	return retryImage(8, 200*time.Millisecond, 10*time.Second, func() (uploadedImage, error) {
+		return createUmbImage(person.Name, person.ImageSource, folderId, properties)
+	})
+}
+
+// Block list of the cast, formatted the same way as genreFormatter
+func castFormatter(cast []CastMember) (string, error) {
+	if len(cast) == 0 {
+		return "", nil
+	}
+
+	var layout Layout
+	var contentData []CastData
+	for _, member := range cast {
+		udi := fmt.Sprintf("umb://element/%s", generateCustomUUID())
+		layout.UmbracoBlockList = append(layout.UmbracoBlockList, ContentUdi{ContentUdi: udi})
+		contentData = append(contentData, CastData{
+			ContentTypeKey: config.CastContentTypeKey,
+			Udi:            udi,
+			Character:      member.Character,
+			Person:         member.Person,
+		})
+	}
+
+	jsonBytes, err := json.MarshalIndent(JSONCast{Layout: layout, ContentData: contentData}, "", "	")
+	if err != nil {
+		return "", err
+	}
+	// Remove the outer curly braces, settingsData is added by sendUmbShow
+	jsonString := string(jsonBytes)
+	jsonString = strings.TrimPrefix(jsonString, "{")
+	jsonString = strings.TrimSuffix(jsonString, "}")
+	jsonString += ","
+
+	return jsonString, nil
+}
+
+func parseUmbCast(umbShow gjson.Result) []CastMember {
+	cast := []CastMember{}
+	umbShow.Get("showCast.$invariant.contentData").ForEach(func(i, member gjson.Result) bool {
+		cast = append(cast, CastMember{Character: member.Get("character").String(), Person: member.Get("person").String()})
+		return true
+	})
+	return cast
+}
+
+// Media keys of the headshots in every people folder, so the media gc and delete keep them
+func getUmbPeopleMedia() ([]string, error) {
+	body, err := umbGet(config.UmbBaseURL + "content")
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, folder := range gjson.GetBytes(body, "_embedded.content").Array() {
+		if folder.Get("contentTypeAlias").String() != PEOPLE_FOLDER_TYPE {
+			continue
+		}
+		err := forEachUmbPage(config.UmbBaseURL+"content/"+folder.Get("_id").String()+"/children", "content", func(item gjson.Result) error {
+			keys = append(keys, mediaKeys(item, "personImage")...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// Used for Umbraco API JSON formatting
+type CastData struct {
+	ContentTypeKey string `json:"contentTypeKey"`
+	Udi            string `json:"udi"`
+	Character      string `json:"character"`
+	Person         string `json:"person"` // Content picker value, the UDI of a Person node
+}
+
+// Used for Umbraco API JSON formatting
+type JSONCast struct {
+	Layout      Layout     `json:"layout"`
+	ContentData []CastData `json:"contentData"`
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+// A top level content folder with one node per TVMaze entity, e.g. the networks. The folder and its
+// nodes are read once, after that nodes are looked up by key and created the first time they are needed
+type ContentCollection struct {
+	FolderName *string // Points into config, so flags are applied before it's read
+	FolderType string  // contentTypeAlias of the folder
+	NodeKey    func(node gjson.Result) string
+
+	mu       sync.Mutex
+	loaded   bool
+	folderId string
+	nodes    map[string]string // key -> _id
+	locks    keyedMutex[string]
+}
+
+// Returns the _id of the node with this key. When it doesn't exist yet newNode builds it and it is POSTed into the folder
+func (c *ContentCollection) Node(key string, newNode func() (map[string]interface{}, error)) (string, error) {
+	folderId, err := c.load()
+	if err != nil {
+		return "", err
+	}
+
+	// One worker per key at a time, so a node is never created twice
+	unlock := c.locks.Lock(key)
+	defer unlock()
+
+	c.mu.Lock()
+	id, exists := c.nodes[key]
+	c.mu.Unlock()
+	if exists {
+		return id, nil
+	}
+
+	node, err := newNode()
+	if err != nil {
+		return "", err
+	}
+	node["parentId"] = folderId
+	id, err = createUmbContent(node)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.nodes[key] = id
+	c.mu.Unlock()
+	return id, nil
+}
+
+// Finds or creates the folder and reads the nodes already in it. Workers wait here until the first one is done
+func (c *ContentCollection) load() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded {
+		return c.folderId, nil
+	}
+
+	folderId, err := findUmbRootNode(c.FolderType, *c.FolderName)
+	if err != nil {
+		return "", err
+	}
+	if folderId == "" {
+		fmt.Printf("Creating content folder %s\n", *c.FolderName)
+		folderId, err = createUmbContent(map[string]interface{}{
+			"contentTypeAlias": c.FolderType,
+			"name":             map[string]string{LANGUAGE: *c.FolderName},
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	nodes := make(map[string]string)
+	err = forEachUmbPage(config.UmbBaseURL+"content/"+folderId+"/children", "content", func(item gjson.Result) error {
+		if key := c.NodeKey(item); key != "" {
+			nodes[key] = item.Get("_id").String()
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	c.folderId, c.nodes, c.loaded = folderId, nodes, true
+	return folderId, nil
+}
+
+// Looks for a node at the top of the content tree, next to the root item. Returns "" when there is none
+func findUmbRootNode(contentTypeAlias string, name string) (string, error) {
+	body, err := umbGet(config.UmbBaseURL + "content")
+	if err != nil {
+		return "", err
+	}
+	id := ""
+	gjson.GetBytes(body, "_embedded.content").ForEach(func(i, node gjson.Result) bool {
+		if node.Get("contentTypeAlias").String() == contentTypeAlias && node.Get("name."+LANGUAGE).String() == name {
+			id = node.Get("_id").String()
+			return false
+		}
+		return true
+	})
+	return id, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Rules for picking which node of a duplicate group survives
+var dedupeRules = map[string]func(a, b gjson.Result) bool{
+	"newest": func(a, b gjson.Result) bool {
+		return umbNodeDate(a).After(umbNodeDate(b))
+	},
+	"image": func(a, b gjson.Result) bool {
+		aImage, bImage := len(showMediaKeys(a)) > 0, len(showMediaKeys(b)) > 0
+		if aImage != bImage {
+			return aImage
+		}
+		return umbNodeDate(a).After(umbNodeDate(b))
+	},
+	"complete": func(a, b gjson.Result) bool {
+		aScore, bScore := showCompleteness(a), showCompleteness(b)
+		if aScore != bScore {
+			return aScore > bScore
+		}
+		return umbNodeDate(a).After(umbNodeDate(b))
+	},
+}
+
+type duplicateGroup struct {
+	ShowId   int
+	Survivor gjson.Result
+	Others   []gjson.Result
+}
+
+func runDedupe(args []string) {
+	flags := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	keep := flags.String("keep", "complete", "Which node to keep: newest, image or complete")
+	action := flags.String("action", "delete", "What to do with the other nodes: delete, or unpublish to keep them. Unpublished nodes still share the showId, so sync and later dedupe runs see them again")
+	yes := flags.Bool("yes", false, "Don't ask for confirmation")
+	dryRun := flags.Bool("dry-run", false, "Only report the duplicates")
+	workers := flags.Int("workers", WORKER_COUNT, "Number of concurrent requests")
+	logPath := flags.String("log", fmt.Sprintf("dedupe-%s.jsonl", time.Now().Format("20060102-150405")), "Log of every node removed")
+	flags.Parse(args)
+	if *workers < 1 {
+		fmt.Println("--workers must be at least 1")
+		os.Exit(2)
+	}
+
+	better, exists := dedupeRules[*keep]
+	if !exists {
+		fmt.Println("Unknown --keep rule:", *keep)
+		os.Exit(2)
+	}
+	if *action != "unpublish" && *action != "delete" {
+		fmt.Println("Unknown --action:", *action)
+		os.Exit(2)
+	}
+
+	if !initUmbRoot(&http.Client{}) {
+		return
+	}
+	fmt.Println("Downloading shows...")
+	content, err := getAllUmbContent()
+	if err != nil {
+		fmt.Println("Unable to fetch umb shows:", err)
+		os.Exit(1)
+	}
+
+	groups := findDuplicates(content, better)
+	targets := []deleteTarget{}
+	for _, group := range groups {
+		fmt.Printf("showId %d: keeping %s, %s", group.ShowId, group.Survivor.Get("_id").String(), *action)
+		for _, other := range group.Others {
+			show := parseUmbShow(other)
+			fmt.Printf(" %s", show.UmbId)
+			targets = append(targets, deleteTarget{Kind: "content", Id: show.UmbId, Name: show.Name, ShowId: show.Id, Unpublish: *action == "unpublish"})
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Found %d shows with duplicates\n", len(groups))
+	if *action == "delete" {
+		fmt.Println("Images of deleted duplicates are left in place, run `gc media` afterwards to remove them")
+	}
+
+	if !confirmDeletion(targets, *yes || *dryRun) {
+		fmt.Println("Aborted")
+		return
+	}
+	if err := executeDeletion(targets, *workers, *logPath, *dryRun); err != nil {
+		fmt.Println("Dedupe failed:", err)
+		os.Exit(1)
+	}
+}
+
+// Groups nodes by showId and returns every group with more than one node, sorted by showId
+func findDuplicates(content []gjson.Result, better func(a, b gjson.Result) bool) []duplicateGroup {
+	byShowId := make(map[int][]gjson.Result)
+	for _, item := range content {
+		show := parseUmbShow(item)
+		if show.Id == 0 {
+			continue
+		}
+		byShowId[show.Id] = append(byShowId[show.Id], item)
+	}
+
+	groups := []duplicateGroup{}
+	for showId, nodes := range byShowId {
+		if len(nodes) < 2 {
+			continue
+		}
+		sort.SliceStable(nodes, func(i, j int) bool { return better(nodes[i], nodes[j]) })
+		groups = append(groups, duplicateGroup{ShowId: showId, Survivor: nodes[0], Others: nodes[1:]})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ShowId < groups[j].ShowId })
+	return groups
+}
+
+// Number of filled in show properties
+func showCompleteness(item gjson.Result) int {
+	show := parseUmbShow(item)
+	score := 0
+	for _, filled := range []bool{show.Name != "", show.Summary != "", len(show.Genres) > 0 || show.GenreNodes != "", show.Image != ""} {
+		if filled {
+			score++
+		}
+	}
+	return score
+}
+
+// Last update of a node, falling back to its creation
+func umbNodeDate(item gjson.Result) time.Time {
+	for _, field := range []string{"_updateDate", "_createDate"} {
+		if date, err := parseUmbDate(item.Get(field).String()); err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
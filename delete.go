@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Something the delete, gc or dedupe commands will remove
+type deleteTarget struct {
+	Kind      string // content or media
+	Id        string
+	Name      string
+	ShowId    int
+	Unpublish bool // Unpublish the content node instead of deleting it
+}
+
+type idRange struct {
+	From int
+	To   int
+}
+
+type deleteFilter struct {
+	Ranges       []idRange
+	Genre        string
+	GenreNode    string // UDI of the Genre node titled Genre, for shows migrated to showGenres
+	ImporterOnly bool   // Only tVShow nodes and media the importer uploaded
+	OrphanedOnly bool   // Only media no show references. Shows are left alone
+}
+
+func runDelete(args []string) {
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	ids := flags.String("ids", "", "Only shows with a showId in these ranges, e.g. 1-500,900")
+	genre := flags.String("genre", "", "Only shows with this genre, in the genres block list or the showGenres picker")
+	flags.StringVar(&config.GenreFolder, "genre-folder", config.GenreFolder, "Name of the folder holding the genre nodes")
+	importerOnly := flags.Bool("importer-only", false, "Only shows and media created by the importer")
+	orphanedOnly := flags.Bool("orphaned-only", false, "Only media not referenced by any show")
+	yes := flags.Bool("yes", false, "Don't ask for confirmation")
+	dryRun := flags.Bool("dry-run", false, "Log what would be deleted without deleting it")
+	workers := flags.Int("workers", WORKER_COUNT, "Number of concurrent deletes")
+	logPath := flags.String("log", fmt.Sprintf("delete-%s.jsonl", time.Now().Format("20060102-150405")), "Deletion log")
+	flags.Parse(args)
+	if *workers < 1 {
+		fmt.Println("--workers must be at least 1")
+		os.Exit(2)
+	}
+
+	ranges, err := parseIdRanges(*ids)
+	if err != nil {
+		fmt.Println("Invalid --ids:", err)
+		os.Exit(2)
+	}
+	filter := deleteFilter{Ranges: ranges, Genre: *genre, ImporterOnly: *importerOnly, OrphanedOnly: *orphanedOnly}
+
+	if !initUmbRoot(&http.Client{}) {
+		return
+	}
+	if filter.Genre != "" {
+		if filter.GenreNode, err = findGenreNodeUdi(filter.Genre); err != nil {
+			fmt.Println("Unable to fetch umb genres:", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Println("Downloading shows and media...")
+	content, err := getAllUmbContent()
+	if err != nil {
+		fmt.Println("Unable to fetch umb shows:", err)
+		os.Exit(1)
+	}
+	childMedia, err := getUmbEpisodeMedia(content)
+	if err != nil {
+		fmt.Println("Unable to fetch umb seasons and episodes:", err)
+		os.Exit(1)
+	}
+	keptMedia, err := getUmbPeopleMedia()
+	if err != nil {
+		fmt.Println("Unable to fetch umb people:", err)
+		os.Exit(1)
+	}
+	media, err := getAllUmbMedia()
+	if err != nil {
+		fmt.Println("Unable to fetch umb media:", err)
+		os.Exit(1)
+	}
+
+	targets := planDeletion(content, childMedia, keptMedia, media, filter)
+	if !confirmDeletion(targets, *yes || *dryRun) {
+		fmt.Println("Aborted")
+		return
+	}
+	if err := executeDeletion(targets, *workers, *logPath, *dryRun); err != nil {
+		fmt.Println("Delete failed:", err)
+		os.Exit(1)
+	}
+}
+
+// Picks the shows matching the filter, and the media that will no longer be referenced once they are gone.
+// childMedia holds the media of the seasons and episodes below each show, which go with it. keptMedia is
+// referenced by nodes outside the shows, like the people, and is never deleted
+func planDeletion(content []gjson.Result, childMedia map[string][]string, keptMedia []string, media []gjson.Result, filter deleteFilter) []deleteTarget {
+	targets := []deleteTarget{}
+	showFilter := len(filter.Ranges) > 0 || filter.Genre != ""
+	deletedMedia := make(map[string]bool)   // Referenced by a show being deleted
+	remainingMedia := make(map[string]bool) // Referenced by a show that stays
+	for _, key := range keptMedia {
+		remainingMedia[key] = true
+	}
+
+	for _, item := range content {
+		show := parseUmbShow(item)
+		keys := append(showMediaKeys(item), childMedia[show.UmbId]...)
+		if !filter.OrphanedOnly && filter.matchesShow(item, show) {
+			targets = append(targets, deleteTarget{Kind: "content", Id: show.UmbId, Name: show.Name, ShowId: show.Id})
+			for _, key := range keys {
+				deletedMedia[key] = true
+			}
+			continue
+		}
+		for _, key := range keys {
+			remainingMedia[key] = true
+		}
+	}
+
+	importerFolders := importerMediaFolders(media)
+	for _, item := range media {
+		if item.Get("mediaTypeAlias").String() == "Folder" {
+			continue
+		}
+		key := item.Get("_id").String()
+		if remainingMedia[key] {
+			continue
+		}
+		if filter.ImporterOnly && !isImporterMedia(item, importerFolders) {
+			continue
+		}
+		if showFilter && !filter.OrphanedOnly && !deletedMedia[key] {
+			continue
+		}
+		if filter.OrphanedOnly && deletedMedia[key] {
+			continue
+		}
+		targets = append(targets, deleteTarget{Kind: "media", Id: key, Name: item.Get("name").String()})
+	}
+	return targets
+}
+
+func (f deleteFilter) matchesShow(item gjson.Result, show Show) bool {
+	if f.ImporterOnly && item.Get("contentTypeAlias").String() != "tVShow" {
+		return false
+	}
+	if len(f.Ranges) > 0 && !slices.ContainsFunc(f.Ranges, func(r idRange) bool { return show.Id >= r.From && show.Id <= r.To }) {
+		return false
+	}
+	if f.Genre != "" && !slices.ContainsFunc(show.Genres, func(g Genre) bool { return strings.EqualFold(g.Title, f.Genre) }) &&
+		(f.GenreNode == "" || !slices.Contains(strings.Split(show.GenreNodes, ","), f.GenreNode)) {
+		return false
+	}
+	return true
+}
+
+// Every media key picked on a show node
+func showMediaKeys(item gjson.Result) []string {
+	return mediaKeys(item, "showImage", "showPoster", "showGallery")
+}
+
+// Keys in the node's media pickers with these aliases
+func mediaKeys(item gjson.Result, aliases ...string) []string {
+	keys := []string{}
+	for _, alias := range aliases {
+		for _, key := range item.Get(alias + ".$invariant.#.mediaKey").Array() {
+			keys = append(keys, key.String())
+		}
+	}
+	return keys
+}
+
+// Media uploaded by createUmbImage, recognised by a property only the importer sets or by being in its media folders.
+// A file name isn't enough, editors upload JPEGs too
+func isImporterMedia(item gjson.Result, importerFolders map[string]bool) bool {
+	if item.Get("mediaTypeAlias").String() != "Image" {
+		return false
+	}
+	for _, alias := range []string{"imageHash", "sourceUrl", "tvMazeShowId"} {
+		if value := item.Get(alias); value.Exists() && value.String() != "" {
+			return true
+		}
+	}
+	return importerFolders[item.Get("parentId").String()]
+}
+
+// IDs of the top level Configs.MediaFolderRoot folder and every folder below it
+func importerMediaFolders(media []gjson.Result) map[string]bool {
+	type folder struct {
+		parentId string
+		name     string
+	}
+	folders := make(map[string]folder)
+	for _, item := range media {
+		if item.Get("mediaTypeAlias").String() == "Folder" {
+			folders[item.Get("_id").String()] = folder{parentId: item.Get("parentId").String(), name: item.Get("name").String()}
+		}
+	}
+
+	importerFolders := make(map[string]bool)
+	for id := range folders {
+		// Walk up to the top level folder, the depth limit guards against a parent loop
+		current := folders[id]
+		for depth := 0; depth < len(folders); depth++ {
+			parent, hasParent := folders[current.parentId]
+			if !hasParent {
+				if current.name == config.MediaFolderRoot {
+					importerFolders[id] = true
+				}
+				break
+			}
+			current = parent
+		}
+	}
+	return importerFolders
+}
+
+func parseIdRanges(value string) ([]idRange, error) {
+	ranges := []idRange{}
+	for _, part := range splitList(value) {
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(to); err != nil {
+				return nil, err
+			}
+		}
+		if end < start {
+			return nil, fmt.Errorf("range %s ends before it starts", part)
+		}
+		ranges = append(ranges, idRange{From: start, To: end})
+	}
+	return ranges, nil
+}
+
+func confirmDeletion(targets []deleteTarget, yes bool) bool {
+	shows, media := 0, 0
+	for _, target := range targets {
+		if target.Kind == "content" {
+			shows++
+		} else {
+			media++
+		}
+	}
+	fmt.Printf("About to remove %d shows and %d media items\n", shows, media)
+	if len(targets) == 0 {
+		return false
+	}
+	if yes {
+		return true
+	}
+	fmt.Print("Type 'yes' to continue: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
+type DeletionLogEntry struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Id     string    `json:"id"`
+	Name   string    `json:"name,omitempty"`
+	ShowId int       `json:"showId,omitempty"`
+	Status string    `json:"status"` // deleted, unpublished, failed or dry-run
+	Error  string    `json:"error,omitempty"`
+}
+
+// Deletes (or unpublishes) the targets concurrently under umbLimiter and writes one log line per target
+func executeDeletion(targets []deleteTarget, workers int, logPath string, dryRun bool) error {
+	defer timeTrack(time.Now(), "Deletion")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	encoder := json.NewEncoder(logFile)
+
+	var mu sync.Mutex
+	deleted, failed := 0, 0
+	forEachConcurrent(targets, workers, func(target deleteTarget) {
+		entry := DeletionLogEntry{Kind: target.Kind, Id: target.Id, Name: target.Name, ShowId: target.ShowId, Status: "dry-run"}
+		if !dryRun {
+			method, url, status := "DELETE", fmt.Sprintf("%s%s/%s", config.UmbBaseURL, target.Kind, target.Id), "deleted"
+			if target.Unpublish {
+				method, url, status = "PUT", url+"/unpublish", "unpublished"
+			}
+			err := retry(4, 200*time.Millisecond, 10*time.Second, func() error {
+				umbLimiter.Wait()
+				_, err := umbSend(method, url, nil)
+				return err
+			})
+			entry.Status = status
+			if err != nil {
+				entry.Status = "failed"
+				entry.Error = err.Error()
+			}
+		}
+		entry.Time = time.Now().UTC()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if entry.Status == "failed" {
+			failed++
+		} else {
+			deleted++
+		}
+		encoder.Encode(entry)
+		fmt.Printf("Removed %d of %d, %d failed         \r", deleted, len(targets), failed)
+	})
+	fmt.Printf("\nRemoved %d items, %d failed. Log written to %s\n", deleted, failed, logPath)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestParseIdRanges(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []idRange
+		wantErr bool
+	}{
+		{value: "", want: []idRange{}},
+		{value: "900", want: []idRange{{900, 900}}},
+		{value: "1-500,900", want: []idRange{{1, 500}, {900, 900}}},
+		{value: " 5 , 7-9 ", want: []idRange{{5, 5}, {7, 9}}},
+		{value: "1,,2,", want: []idRange{{1, 1}, {2, 2}}},
+		{value: "3-3", want: []idRange{{3, 3}}},
+		{value: "10-1", wantErr: true},
+		{value: "abc", wantErr: true},
+		{value: "1-b", wantErr: true},
+		{value: "-5", wantErr: true},
+		{value: "1-2-3", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseIdRanges(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseIdRanges(%q) = %v, want an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseIdRanges(%q) failed: %v", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseIdRanges(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestPlanDeletion(t *testing.T) {
+	content := []gjson.Result{
+		gjson.Parse(`{"_id":"a","contentTypeAlias":"tVShow","name":{"en-US":"A"},"showId":{"$invariant":1},
+			"genres":{"$invariant":{"contentData":[{"title":"Drama"}]}},"showImage":{"$invariant":[{"mediaKey":"ma"}]}}`),
+		gjson.Parse(`{"_id":"b","contentTypeAlias":"tVShow","name":{"en-US":"B"},"showId":{"$invariant":2},
+			"genres":{"$invariant":{"contentData":[{"title":"Comedy"}]}},"showImage":{"$invariant":[{"mediaKey":"mb"}]}}`),
+		// Migrated by genres migrate: the block list is empty and showGenres picks the Genre nodes
+		gjson.Parse(`{"_id":"c","contentTypeAlias":"tVShow","name":{"en-US":"C"},"showId":{"$invariant":3},
+			"genres":{"$invariant":{"contentData":[]}},"showGenres":{"$invariant":"umb://document/g1,umb://document/g2"}}`),
+		gjson.Parse(`{"_id":"page","contentTypeAlias":"textPage","name":{"en-US":"About"}}`),
+	}
+	childMedia := map[string][]string{"b": {"mbe"}} // An episode image of show b
+	keptMedia := []string{"mk"}                     // e.g. a headshot
+	media := []gjson.Result{
+		gjson.Parse(`{"_id":"f","mediaTypeAlias":"Folder","name":"Site"}`),
+		gjson.Parse(`{"_id":"ma","mediaTypeAlias":"Image","name":"A.jpg","imageHash":"1"}`),
+		gjson.Parse(`{"_id":"mb","mediaTypeAlias":"Image","name":"B.jpg","imageHash":"2"}`),
+		gjson.Parse(`{"_id":"mbe","mediaTypeAlias":"Image","name":"B S01E01.jpg","sourceUrl":"https://static.tvmaze.com/1.jpg"}`),
+		gjson.Parse(`{"_id":"mo","mediaTypeAlias":"Image","name":"Old.jpg","tvMazeShowId":3}`),
+		gjson.Parse(`{"_id":"mk","mediaTypeAlias":"Image","name":"Person.jpg","imageHash":"4"}`),
+		gjson.Parse(`{"_id":"me","mediaTypeAlias":"Image","name":"logo.png","parentId":"f"}`),
+	}
+
+	tests := []struct {
+		name   string
+		filter deleteFilter
+		want   []string
+	}{
+		{"everything", deleteFilter{}, []string{"content:a", "content:b", "content:c", "content:page", "media:ma", "media:mb", "media:mbe", "media:me", "media:mo"}},
+		{"ids", deleteFilter{Ranges: []idRange{{1, 1}}}, []string{"content:a", "media:ma"}},
+		{"genre", deleteFilter{Genre: "comedy"}, []string{"content:b", "media:mb", "media:mbe"}},
+		{"genre picker", deleteFilter{Genre: "Crime", GenreNode: "umb://document/g2"}, []string{"content:c"}},
+		{"genre without a node", deleteFilter{Genre: "Crime"}, []string{}},
+		{"ids and genre", deleteFilter{Ranges: []idRange{{1, 2}}, Genre: "Drama"}, []string{"content:a", "media:ma"}},
+		{"no match", deleteFilter{Ranges: []idRange{{5, 9}}}, []string{}},
+		{"importer only", deleteFilter{ImporterOnly: true}, []string{"content:a", "content:b", "content:c", "media:ma", "media:mb", "media:mbe", "media:mo"}},
+		{"importer only with ids", deleteFilter{Ranges: []idRange{{2, 2}}, ImporterOnly: true}, []string{"content:b", "media:mb", "media:mbe"}},
+		{"orphaned only", deleteFilter{OrphanedOnly: true}, []string{"media:me", "media:mo"}},
+		{"orphaned only ignores show filters", deleteFilter{Ranges: []idRange{{1, 1}}, OrphanedOnly: true}, []string{"media:me", "media:mo"}},
+		{"importer and orphaned only", deleteFilter{ImporterOnly: true, OrphanedOnly: true}, []string{"media:mo"}},
+	}
+	for _, test := range tests {
+		got := []string{}
+		for _, target := range planDeletion(content, childMedia, keptMedia, media, test.filter) {
+			got = append(got, target.Kind+":"+target.Id)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const DUMP_MANIFEST = "manifest.json"
+
+var errMazeEnd = errors.New("end of shows API reached")
+
+// Written next to the page files of a dump. Lists every page so a dump can be verified and replayed
+type DumpManifest struct {
+	CreatedAt  time.Time  `json:"createdAt"`
+	Source     string     `json:"source"`
+	Embeds     []string   `json:"embeds,omitempty"`
+	TotalShows int        `json:"totalShows"`
+	Pages      []DumpPage `json:"pages"`
+}
+
+type DumpPage struct {
+	Page   int    `json:"page"`
+	File   string `json:"file"`   // One show per line, gzip compressed
+	Shows  int    `json:"shows"`  // Number of lines in the file
+	SHA256 string `json:"sha256"` // Checksum of the compressed file
+}
+
+func runTVMaze(args []string) {
+	if len(args) == 0 || args[0] != "dump" {
+		printUsage()
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("tvmaze dump", flag.ExitOnError)
+	outDir := flags.String("out", "tvmaze-dump", "Directory to write the dump to")
+	embed := flags.String("embed", "", "Comma separated per-show embeds to include, e.g. cast,seasons,episodes")
+	flags.Parse(args[1:])
+
+	if err := dumpTVMaze(*outDir, splitList(*embed)); err != nil {
+		fmt.Println("Dump failed:", err)
+		os.Exit(1)
+	}
+}
+
+// Downloads every /shows?page=N page into dir. With embeds every show is fetched again from /shows/{id} with those embeds
+func dumpTVMaze(dir string, embeds []string) error {
+	defer timeTrack(time.Now(), "TVMaze dump")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	manifest := DumpManifest{
+		CreatedAt: time.Now().UTC(),
+		Source:    config.MazeBaseURL,
+		Embeds:    embeds,
+	}
+	for page := 0; ; page++ {
+		data, err := fetchMazeRetry(config.MazeBaseURL + strconv.Itoa(page))
+		if errors.Is(err, errMazeEnd) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("page %d: %w", page, err)
+		}
+
+		lines := []string{}
+		gjson.ParseBytes(data).ForEach(func(i, show gjson.Result) bool {
+			lines = append(lines, show.Raw)
+			return true // Keep iterating
+		})
+		if len(embeds) > 0 {
+			lines = embedShows(lines, embeds)
+		}
+
+		dumpPage, err := writeDumpPage(dir, page, lines)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", page, err)
+		}
+		manifest.Pages = append(manifest.Pages, dumpPage)
+		manifest.TotalShows += dumpPage.Shows
+
+		// Rewritten after every page so an interrupted dump can still be used
+		if err := writeJSONFile(filepath.Join(dir, DUMP_MANIFEST), manifest); err != nil {
+			return err
+		}
+		fmt.Printf("Dumped page %4d (%d shows)\r", page, dumpPage.Shows)
+	}
+	fmt.Printf("\nDumped %d pages with %d shows to %s\n", len(manifest.Pages), manifest.TotalShows, dir)
+	return nil
+}
+
+// Replaces each show with the /shows/{id} version including the embeds. Shows that fail keep their page data
+func embedShows(lines []string, embeds []string) []string {
+	query := "?embed[]=" + strings.Join(embeds, "&embed[]=")
+	result := make([]string, len(lines))
+	copy(result, lines)
+
+	var wg sync.WaitGroup
+	indexChan := make(chan int)
+	for i := 0; i < WORKER_COUNT; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexChan {
+				id := gjson.Get(lines[index], "id").Int()
+				data, err := fetchMazeRetry(fmt.Sprintf("%s%d%s", config.MazeShowURL, id, query))
+				if err != nil {
+					fmt.Printf("Failed to fetch embeds for show %d: %v\n", id, err)
+					continue
+				}
+				result[index] = string(data)
+			}
+		}()
+	}
+	for i := range lines {
+		indexChan <- i
+	}
+	close(indexChan)
+	wg.Wait()
+	return result
+}
+
+func writeDumpPage(dir string, page int, lines []string) (DumpPage, error) {
+	dumpPage := DumpPage{Page: page, File: fmt.Sprintf("shows-%05d.jsonl.gz", page)}
+
+	file, err := os.Create(filepath.Join(dir, dumpPage.File))
+	if err != nil {
+		return dumpPage, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(file, hash))
+	for _, line := range lines {
+		compact := &bytes.Buffer{}
+		if err := json.Compact(compact, []byte(line)); err != nil {
+			return dumpPage, err
+		}
+		compact.WriteByte('\n')
+		if _, err := gz.Write(compact.Bytes()); err != nil {
+			return dumpPage, err
+		}
+		dumpPage.Shows++
+	}
+	if err := gz.Close(); err != nil {
+		return dumpPage, err
+	}
+	dumpPage.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return dumpPage, file.Close()
+}
+
+// GETs a TVMaze URL under the rate limiter. A 404 returns errMazeEnd
+func fetchMaze(url string) ([]byte, error) {
+	mazeLimiter.Wait()
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errMazeEnd
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status error: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// fetchMaze with retries, except for errMazeEnd which is returned straight away
+func fetchMazeRetry(url string) ([]byte, error) {
+	var data []byte
+	var end bool
+	err := retry(8, 200*time.Millisecond, 10*time.Second, func() error {
+		var err error
+		data, err = fetchMaze(url)
+		if errors.Is(err, errMazeEnd) {
+			end = true
+			return nil
+		}
+		return err
+	})
+	if end {
+		return nil, errMazeEnd
+	}
+	return data, err
+}
+
+// Reads pages from a directory written by dumpTVMaze
+type dumpSource struct {
+	dir      string
+	pages    map[int]DumpPage
+	lastPage int
+}
+
+func openDumpSource(dir string) (*dumpSource, error) {
+	manifestData, err := os.ReadFile(filepath.Join(dir, DUMP_MANIFEST))
+	if err != nil {
+		return nil, err
+	}
+	manifest := DumpManifest{}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	source := &dumpSource{dir: dir, pages: make(map[int]DumpPage), lastPage: -1}
+	for _, page := range manifest.Pages {
+		source.pages[page.Page] = page
+		source.lastPage = max(source.lastPage, page.Page)
+	}
+	fmt.Printf("Using dump from %s with %d pages and %d shows\n", manifest.CreatedAt.Format(time.RFC3339), len(manifest.Pages), manifest.TotalShows)
+	return source, nil
+}
+
+func (d *dumpSource) Page(page int) ([]Show, error) {
+	defer timeTrack(time.Now(), "Reading a dump page")
+	dumpPage, exists := d.pages[page]
+	if !exists {
+		return []Show{}, errMazeEnd
+	}
+
+	data, err := os.ReadFile(filepath.Join(d.dir, dumpPage.File))
+	if err != nil {
+		return []Show{}, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != dumpPage.SHA256 {
+		return []Show{}, fmt.Errorf("checksum mismatch for %s", dumpPage.File)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return []Show{}, err
+	}
+	defer gz.Close()
+
+	// Turn the JSONL back into the array TVMaze returns, so it parses the same way
+	showsData := &bytes.Buffer{}
+	showsData.WriteByte('[')
+	reader := bufio.NewReader(gz)
+	for {
+		line, err := reader.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			if showsData.Len() > 1 {
+				showsData.WriteByte(',')
+			}
+			showsData.Write(line)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return []Show{}, err
+		}
+	}
+	showsData.WriteByte(']')
+	return parseMazeShows(showsData.Bytes()), nil
+}
+
+func (d *dumpSource) LastPage() int {
+	return d.lastPage
+}
+
+// Splits a comma separated flag value, dropping empty entries
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "	")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const EPISODE_WORKERS = 3 // Seasons of one show synced at the same time
+
+// Season node below a show. Found in umbraco as contentTypeAlias season   found in TVMaze: /shows/{id}/seasons
+type Season struct {
+	UmbId       string // Found in umbraco ~content._id
+	Id          int    // Found in umbraco: ~content.seasonId.$invariant   found in TVMaze: id
+	Number      int    // Found in umbraco: ~content.seasonNumber.$invariant   found in TVMaze: number
+	Name        string // Found in umbraco: ~content.name.en-US   found in TVMaze: name, or "Season {number}" when empty
+	Premiered   string // YYYY-MM-DD. Found in umbraco: ~content.seasonPremiered.$invariant   found in TVMaze: premiereDate
+	Summary     string // Found in umbraco: ~content.seasonSummary.en-US.markup   found in TVMaze: summary
+	Image       string // Found in umbraco: ~content.seasonImage.$invariant.[].mediaKey
+	ImageSource string // Found in umbraco: ~content.seasonImageSource.$invariant   found in TVMaze: image.medium or image.original
+}
+
+// Episode node below its season. Found in umbraco as contentTypeAlias episode   found in TVMaze: /shows/{id}/episodes
+type Episode struct {
+	UmbId       string
+	Id          int    // Found in umbraco: ~content.episodeId.$invariant   found in TVMaze: id
+	Season      int    // Season number, used to find the parent. found in TVMaze: season
+	Number      int    // 0 for specials. Found in umbraco: ~content.episodeNumber.$invariant   found in TVMaze: number
+	Name        string // Found in umbraco: ~content.name.en-US   found in TVMaze: name
+	Airdate     string // YYYY-MM-DD. Found in umbraco: ~content.episodeAirdate.$invariant   found in TVMaze: airdate
+	Runtime     int    // Minutes. Found in umbraco: ~content.episodeRuntime.$invariant   found in TVMaze: runtime
+	Summary     string // Found in umbraco: ~content.episodeSummary.en-US.markup   found in TVMaze: summary
+	Image       string
+	ImageSource string
+}
+
+// Creates, updates or skips the Season and Episode nodes below the show, the same way shows are synced.
+// TVMaze requests go through mazeLimiter and Umbraco writes through umbLimiter
+func syncEpisodes(show Show, folderId string) error {
+	seasonsData, err := fetchMazeRetry(fmt.Sprintf("%s%d/seasons", config.MazeShowURL, show.Id))
+	if err != nil {
+		return err
+	}
+	episodesData, err := fetchMazeRetry(fmt.Sprintf("%s%d/episodes", config.MazeShowURL, show.Id))
+	if err != nil {
+		return err
+	}
+	episodesBySeason := make(map[int][]Episode)
+	for _, episode := range parseMazeEpisodes(episodesData) {
+		episodesBySeason[episode.Season] = append(episodesBySeason[episode.Season], episode)
+	}
+
+	umbSeasons := make(map[int]Season)
+	err = forEachUmbPage(config.UmbBaseURL+"content/"+show.UmbId+"/children", "content", func(item gjson.Result) error {
+		if item.Get("contentTypeAlias").String() == "season" {
+			season := parseUmbSeason(item)
+			umbSeasons[season.Id] = season
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	forEachConcurrent(parseMazeSeasons(seasonsData), EPISODE_WORKERS, func(season Season) {
+		err := syncSeason(show, folderId, season, umbSeasons, episodesBySeason[season.Number])
+		if err != nil {
+			fmt.Printf("Failed to sync season %d of show %d: %v\n", season.Number, show.Id, err)
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+		}
+	})
+	return firstErr
+}
+
+func syncSeason(show Show, folderId string, season Season, umbSeasons map[int]Season, episodes []Episode) error {
+	umbSeason, exists := umbSeasons[season.Id]
+	seasonName := fmt.Sprintf("%s %s", show.Name, season.Name)
+	image := updateShowImage(seasonName, folderId, umbSeason.Image, umbSeason.ImageSource, season.ImageSource, mediaProperties("Poster", seasonName, show.Id))
+	season.UmbId, season.Image, season.ImageSource = umbSeason.UmbId, image.Key, image.Source
+	if exists {
+		if season != umbSeason {
+			umbLimiter.Wait()
+			if err := updateUmbContent(season.UmbId, withParent(seasonNode(season), show.UmbId)); err != nil {
+				return err
+			}
+		}
+	} else {
+		umbLimiter.Wait()
+		id, err := createUmbContent(withParent(seasonNode(season), show.UmbId))
+		if err != nil {
+			return err
+		}
+		season.UmbId = id
+	}
+
+	// A new season has no episodes yet
+	umbEpisodes := make(map[int]Episode)
+	if exists {
+		err := forEachUmbPage(config.UmbBaseURL+"content/"+season.UmbId+"/children", "content", func(item gjson.Result) error {
+			if item.Get("contentTypeAlias").String() == "episode" {
+				episode := parseUmbEpisode(item)
+				umbEpisodes[episode.Id] = episode
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, episode := range episodes {
+		name := fmt.Sprintf("%s S%02dE%02d", show.Name, episode.Season, episode.Number)
+		umbEpisode, exists := umbEpisodes[episode.Id]
+		image := updateShowImage(name, folderId, umbEpisode.Image, umbEpisode.ImageSource, episode.ImageSource, mediaProperties("Still", name, show.Id))
+		episode.Image, episode.ImageSource = image.Key, image.Source
+		// The season number only picks the parent, it isn't a property
+		episode.UmbId, episode.Season = umbEpisode.UmbId, umbEpisode.Season
+		if exists && episode == umbEpisode {
+			continue
+		}
+
+		var err error
+		umbLimiter.Wait()
+		if exists {
+			err = updateUmbContent(episode.UmbId, withParent(episodeNode(episode), season.UmbId))
+		} else {
+			_, err = createUmbContent(withParent(episodeNode(episode), season.UmbId))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseMazeSeasons(data []byte) []Season {
+	seasons := []Season{}
+	gjson.ParseBytes(data).ForEach(func(i, item gjson.Result) bool {
+		season := Season{
+			Id:          int(item.Get("id").Int()),
+			Number:      int(item.Get("number").Int()),
+			Name:        item.Get("name").String(),
+			Premiered:   item.Get("premiereDate").String(),
+			Summary:     item.Get("summary").String(),
+			ImageSource: item.Get("image." + config.ImageVariant).String(),
+		}
+		if season.Name == "" {
+			season.Name = fmt.Sprintf("Season %d", season.Number)
+		}
+		seasons = append(seasons, season)
+		return true
+	})
+	return seasons
+}
+
+func parseMazeEpisodes(data []byte) []Episode {
+	episodes := []Episode{}
+	gjson.ParseBytes(data).ForEach(func(i, item gjson.Result) bool {
+		episode := Episode{
+			Id:          int(item.Get("id").Int()),
+			Season:      int(item.Get("season").Int()),
+			Number:      int(item.Get("number").Int()),
+			Name:        item.Get("name").String(),
+			Airdate:     item.Get("airdate").String(),
+			Runtime:     int(item.Get("runtime").Int()),
+			Summary:     item.Get("summary").String(),
+			ImageSource: item.Get("image." + config.ImageVariant).String(),
+		}
+		if episode.Name == "" {
+			episode.Name = fmt.Sprintf("Episode %d", episode.Number)
+		}
+		episodes = append(episodes, episode)
+		return true
+	})
+	return episodes
+}
+
+func parseUmbSeason(item gjson.Result) Season {
+	return Season{
+		UmbId:       item.Get("_id").String(),
+		Id:          int(item.Get("seasonId.$invariant").Int()),
+		Number:      int(item.Get("seasonNumber.$invariant").Int()),
+		Name:        item.Get("name." + LANGUAGE).String(),
+		Premiered:   umbDateOnly(item.Get("seasonPremiered.$invariant").String()),
+		Summary:     item.Get("seasonSummary." + LANGUAGE + ".markup").String(),
+		Image:       item.Get("seasonImage.$invariant.0.mediaKey").String(),
+		ImageSource: item.Get("seasonImageSource.$invariant").String(),
+	}
+}
+
+func parseUmbEpisode(item gjson.Result) Episode {
+	return Episode{
+		UmbId:       item.Get("_id").String(),
+		Id:          int(item.Get("episodeId.$invariant").Int()),
+		Number:      int(item.Get("episodeNumber.$invariant").Int()),
+		Name:        item.Get("name." + LANGUAGE).String(),
+		Airdate:     umbDateOnly(item.Get("episodeAirdate.$invariant").String()),
+		Runtime:     int(item.Get("episodeRuntime.$invariant").Int()),
+		Summary:     item.Get("episodeSummary." + LANGUAGE + ".markup").String(),
+		Image:       item.Get("episodeImage.$invariant.0.mediaKey").String(),
+		ImageSource: item.Get("episodeImageSource.$invariant").String(),
+	}
+}
+
+func seasonNode(season Season) map[string]interface{} {
+	return map[string]interface{}{
+		"contentTypeAlias":  "season",
+		"name":              map[string]string{LANGUAGE: season.Name},
+		"seasonId":          invariant(season.Id),
+		"seasonNumber":      invariant(season.Number),
+		"seasonPremiered":   invariant(season.Premiered),
+		"seasonSummary":     map[string]string{LANGUAGE: season.Summary},
+		"seasonImage":       invariant(mediaPicker(season.Image)),
+		"seasonImageSource": invariant(season.ImageSource),
+	}
+}
+
+func episodeNode(episode Episode) map[string]interface{} {
+	return map[string]interface{}{
+		"contentTypeAlias":   "episode",
+		"name":               map[string]string{LANGUAGE: episode.Name},
+		"episodeId":          invariant(episode.Id),
+		"episodeNumber":      invariant(episode.Number),
+		"episodeAirdate":     invariant(episode.Airdate),
+		"episodeRuntime":     invariant(episode.Runtime),
+		"episodeSummary":     map[string]string{LANGUAGE: episode.Summary},
+		"episodeImage":       invariant(mediaPicker(episode.Image)),
+		"episodeImageSource": invariant(episode.ImageSource),
+	}
+}
+
+// Invariant property value. Zero values are sent as null, like the ShowDetails properties
+func invariant(value interface{}) map[string]interface{} {
+	return map[string]interface{}{"$invariant": nullIfZero(value)}
+}
+
+// Value of a media picker holding mediaKey, or none
+func mediaPicker(mediaKey string) []map[string]string {
+	if mediaKey == "" {
+		return []map[string]string{}
+	}
+	return []map[string]string{{"mediaKey": mediaKey}}
+}
+
+func withParent(node map[string]interface{}, parentId string) map[string]interface{} {
+	node["parentId"] = parentId
+	return node
+}
+
+// Media keys of the seasons and episodes below each show, by the show's _id, so the media gc and delete don't take
+// their images for orphans. Every show with children is read, a failed episode sync leaves nodes without marking the show
+func getUmbEpisodeMedia(content []gjson.Result) (map[string][]string, error) {
+	defer timeTrack(time.Now(), "Download all umb seasons and episodes")
+	shows := []gjson.Result{}
+	for _, item := range content {
+		if item.Get("contentTypeAlias").String() != "tVShow" {
+			continue
+		}
+		// Read the children when the API doesn't say whether there are any
+		if hasChildren := item.Get("_hasChildren"); hasChildren.Exists() && !hasChildren.Bool() {
+			continue
+		}
+		shows = append(shows, item)
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	childMedia := make(map[string][]string)
+	forEachConcurrent(shows, WORKER_COUNT, func(show gjson.Result) {
+		keys := []string{}
+		err := forEachUmbPage(config.UmbBaseURL+"content/"+show.Get("_id").String()+"/children", "content", func(season gjson.Result) error {
+			keys = append(keys, mediaKeys(season, "seasonImage")...)
+			return forEachUmbPage(config.UmbBaseURL+"content/"+season.Get("_id").String()+"/children", "content", func(episode gjson.Result) error {
+				keys = append(keys, mediaKeys(episode, "episodeImage")...)
+				return nil
+			})
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		childMedia[show.Get("_id").String()] = keys
+	})
+	return childMedia, firstErr
+}
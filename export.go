@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const SNAPSHOT_MANIFEST = "manifest.json"
+
+// Written last into a snapshot. Every other file in the snapshot is listed with its checksum
+type SnapshotManifest struct {
+	CreatedAt time.Time      `json:"createdAt"`
+	RootId    string         `json:"rootId"`
+	Content   int            `json:"content"`
+	Media     int            `json:"media"`
+	Files     []SnapshotFile `json:"files"`
+	Missing   []string       `json:"missing,omitempty"` // Media ids whose binary could not be downloaded or written
+}
+
+type SnapshotFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Snapshot layout:
+//
+//	content/<umbId>.json             raw content node as returned by the API, the shows and their seasons and episodes
+//	media/<mediaId>.json             raw media item as returned by the API
+//	media/files/<mediaId>/<filename> the media binary
+//	manifest.json
+type snapshotWriter interface {
+	WriteFile(name string, data []byte) error
+	Close() error
+}
+
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", fmt.Sprintf("backup-%s.tar.gz", time.Now().Format("20060102-150405")), "Snapshot to write. Ends in .tar.gz for an archive, anything else is a directory")
+	withFiles := flags.Bool("files", true, "Download the media binaries")
+	allowMissing := flags.Bool("allow-missing", false, "Succeed even when some media binaries could not be downloaded. They are listed in the manifest either way")
+	flags.Parse(args)
+
+	if !initUmbRoot(&http.Client{}) {
+		return
+	}
+	if err := exportSnapshot(*out, *withFiles, *allowMissing); err != nil {
+		fmt.Println("Export failed:", err)
+		os.Exit(1)
+	}
+}
+
+// An incomplete snapshot is still written, but is an error unless allowMissing is set
+func exportSnapshot(out string, withFiles bool, allowMissing bool) error {
+	defer timeTrack(time.Now(), "Export")
+	writer, err := newSnapshotWriter(out)
+	if err != nil {
+		return err
+	}
+	snapshot := &checksumWriter{writer: writer}
+	manifest := SnapshotManifest{CreatedAt: time.Now().UTC(), RootId: config.UmbRootItemId}
+
+	fmt.Println("Exporting content...")
+	err = forEachUmbContent(func(item gjson.Result) error {
+		manifest.Content++
+		fmt.Printf("Content: %6d\r", manifest.Content)
+		return snapshot.WriteFile(path.Join("content", item.Get("_id").String()+".json"), []byte(item.Raw))
+	})
+	if err != nil {
+		writer.Close()
+		return err
+	}
+
+	fmt.Println("\nExporting media...")
+	mediaItems := []gjson.Result{}
+	err = forEachUmbMedia(func(item gjson.Result) error {
+		mediaItems = append(mediaItems, item)
+		return snapshot.WriteFile(path.Join("media", item.Get("_id").String()+".json"), []byte(item.Raw))
+	})
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	manifest.Media = len(mediaItems)
+
+	if withFiles {
+		fmt.Println("Downloading media files...")
+		manifest.Missing = downloadMediaFiles(snapshot, mediaItems)
+	}
+
+	manifest.Files = snapshot.files
+	data, err := json.MarshalIndent(manifest, "", "	")
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.WriteFile(SNAPSHOT_MANIFEST, data); err != nil {
+		writer.Close()
+		return err
+	}
+	fmt.Printf("\nExported %d content nodes and %d media items to %s\n", manifest.Content, manifest.Media, out)
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if len(manifest.Missing) > 0 {
+		fmt.Printf("%d media files are missing from the snapshot: %s\n", len(manifest.Missing), strings.Join(manifest.Missing, ", "))
+		if !allowMissing {
+			return fmt.Errorf("snapshot is incomplete, %d media files could not be downloaded", len(manifest.Missing))
+		}
+	}
+	return nil
+}
+
+// Downloads the binaries of the media items into the snapshot. Returns the ids of the ones that failed
+func downloadMediaFiles(snapshot *checksumWriter, mediaItems []gjson.Result) []string {
+	missing := []string{}
+	var wg sync.WaitGroup
+	itemChan := make(chan gjson.Result)
+	count := 0
+	var countMu sync.Mutex
+	for i := 0; i < WORKER_COUNT; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range itemChan {
+				mediaId := item.Get("_id").String()
+				src := item.Get("umbracoFile.src").String()
+				if src == "" {
+					continue
+				}
+				var data []byte
+				err := retry(4, 200*time.Millisecond, 10*time.Second, func() error {
+					var err error
+					data, err = downloadFile(umbMediaFileURL(src))
+					return err
+				})
+				if err == nil {
+					err = snapshot.WriteFile(path.Join("media", "files", mediaId, path.Base(src)), data)
+				}
+				if err != nil {
+					fmt.Printf("Failed to export media %s: %v\n", mediaId, err)
+					countMu.Lock()
+					missing = append(missing, mediaId)
+					countMu.Unlock()
+					continue
+				}
+
+				countMu.Lock()
+				count++
+				fmt.Printf("Files: %6d of %6d\r", count, len(mediaItems))
+				countMu.Unlock()
+			}
+		}()
+	}
+	for _, item := range mediaItems {
+		if item.Get("mediaTypeAlias").String() != "Folder" {
+			itemChan <- item
+		}
+	}
+	close(itemChan)
+	wg.Wait()
+	sort.Strings(missing)
+	return missing
+}
+
+func downloadFile(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status error: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Records the checksum of everything written and serializes writes from the download workers
+type checksumWriter struct {
+	writer snapshotWriter
+	files  []SnapshotFile
+	mu     sync.Mutex
+}
+
+func (c *checksumWriter) WriteFile(name string, data []byte) error {
+	sum := sha256.Sum256(data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files = append(c.files, SnapshotFile{Name: name, Size: len(data), SHA256: hex.EncodeToString(sum[:])})
+	return c.writer.WriteFile(name, data)
+}
+
+func newSnapshotWriter(out string) (snapshotWriter, error) {
+	if strings.HasSuffix(out, ".tar.gz") || strings.HasSuffix(out, ".tgz") {
+		file, err := os.Create(out)
+		if err != nil {
+			return nil, err
+		}
+		gz := gzip.NewWriter(file)
+		return &tarSnapshotWriter{file: file, gz: gz, tar: tar.NewWriter(gz)}, nil
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return nil, err
+	}
+	return dirSnapshotWriter(out), nil
+}
+
+type dirSnapshotWriter string
+
+func (d dirSnapshotWriter) WriteFile(name string, data []byte) error {
+	fullPath := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(fullPath, data, 0644)
+}
+
+func (d dirSnapshotWriter) Close() error {
+	return nil
+}
+
+type tarSnapshotWriter struct {
+	file *os.File
+	gz   *gzip.Writer
+	tar  *tar.Writer
+}
+
+func (t *tarSnapshotWriter) WriteFile(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := t.tar.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := t.tar.Write(data)
+	return err
+}
+
+func (t *tarSnapshotWriter) Close() error {
+	if err := t.tar.Close(); err != nil {
+		t.file.Close()
+		return err
+	}
+	if err := t.gz.Close(); err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
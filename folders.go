@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/tidwall/gjson"
+)
+
+const FOLDER_BUCKET_SIZE = 1000
+
+// Name of the folder a show's images go in, per --media-folders mode
+var mediaFolderNames = map[string]func(show Show) string{
+	"letter": func(show Show) string {
+		first := []rune(strings.TrimSpace(show.Name))
+		if len(first) > 0 && unicode.IsLetter(first[0]) {
+			return string(unicode.ToUpper(first[0]))
+		}
+		return "#"
+	},
+	"year": func(show Show) string {
+		if len(show.Premiered) >= 4 {
+			return show.Premiered[:4]
+		}
+		return "Unknown"
+	},
+	"bucket": func(show Show) string {
+		start := show.Id / FOLDER_BUCKET_SIZE * FOLDER_BUCKET_SIZE
+		return fmt.Sprintf("%d-%d", start, start+FOLDER_BUCKET_SIZE-1)
+	},
+}
+
+// Cache of media folder IDs, creating folders the first time they are needed
+var mediaFolders = &MediaFolders{ids: make(map[string]string), listed: make(map[string]bool)}
+
+type MediaFolders struct {
+	mu     sync.Mutex
+	ids    map[string]string // parentId + "/" + name -> folder id. The media root is parentId ""
+	listed map[string]bool   // Parents whose existing folders have been read
+	locks  keyedMutex[string]
+}
+
+// Media folder for a show's images, or "" for the media root
+func mediaFolderFor(show Show) (string, error) {
+	folderName, exists := mediaFolderNames[config.MediaFolders]
+	if !exists {
+		return "", nil
+	}
+	return mediaFolders.Path(config.MediaFolderRoot, folderName(show))
+}
+
+// Returns the ID of the folder at the path below the media root, creating what is missing
+func (m *MediaFolders) Path(names ...string) (string, error) {
+	parentId := ""
+	for _, name := range names {
+		id, err := m.child(parentId, name)
+		if err != nil {
+			return "", err
+		}
+		parentId = id
+	}
+	return parentId, nil
+}
+
+func (m *MediaFolders) child(parentId string, name string) (string, error) {
+	// One worker per parent at a time, so a folder is never created twice
+	unlock := m.locks.Lock(parentId)
+	defer unlock()
+
+	cacheKey := parentId + "/" + name
+	m.mu.Lock()
+	id, cached := m.ids[cacheKey]
+	listed := m.listed[parentId]
+	m.mu.Unlock()
+	if cached {
+		return id, nil
+	}
+
+	if !listed {
+		if err := m.list(parentId); err != nil {
+			return "", err
+		}
+		m.mu.Lock()
+		id, cached = m.ids[cacheKey]
+		m.mu.Unlock()
+		if cached {
+			return id, nil
+		}
+	}
+
+	err := retry(4, 200*time.Millisecond, 10*time.Second, func() error {
+		var err error
+		id, err = createUmbFolder(name, parentId)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	m.ids[cacheKey] = id
+	m.mu.Unlock()
+	return id, nil
+}
+
+// Caches the folders that already exist directly below parentId
+func (m *MediaFolders) list(parentId string) error {
+	url := config.UmbBaseURL + "media"
+	if parentId != "" {
+		url = fmt.Sprintf("%smedia/%s/children", config.UmbBaseURL, parentId)
+	}
+	folders := make(map[string]string)
+	err := forEachUmbPage(url, "media", func(item gjson.Result) error {
+		if item.Get("mediaTypeAlias").String() == "Folder" {
+			folders[parentId+"/"+item.Get("name").String()] = item.Get("_id").String()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for cacheKey, id := range folders {
+		if _, exists := m.ids[cacheKey]; !exists {
+			m.ids[cacheKey] = id
+		}
+	}
+	m.listed[parentId] = true
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Position of each TVMaze image type in showGallery. Other types go last
+var galleryTypeOrder = map[string]int{"poster": 0, "banner": 1, "background": 2}
+
+// One uploaded gallery image. Only the TVMaze fields go in showGallerySource, the key is read from the picker
+type GalleryImage struct {
+	Id   int    `json:"id"`            // TVMaze image ID. found in TVMaze: /shows/{id}/images id
+	Type string `json:"type"`          // found in TVMaze: type
+	Main bool   `json:"main"`          // found in TVMaze: main
+	Key  string `json:"key,omitempty"` // Media key. Found in umbraco: ~content.showGallery.$invariant.[].mediaKey
+}
+
+// An image from TVMaze's /shows/{id}/images
+type mazeImage struct {
+	Id     int
+	Type   string // poster, banner, background or typography
+	Main   bool
+	Source string // resolutions.medium.url or resolutions.original.url, see Configs.ImageVariant
+}
+
+// Fetches the show's images and uploads the ones not in current, matched by TVMaze image ID. Returns the new
+// gallery and the media keys that dropped out of it. Failed and skipped uploads are left out and reported in err
+func syncGallery(show Show, folderId string, current []GalleryImage) ([]GalleryImage, []string, error) {
+	data, err := fetchMazeRetry(fmt.Sprintf("%s%d/images", config.MazeShowURL, show.Id))
+	if err != nil {
+		return current, nil, err
+	}
+	existing := make(map[int]string, len(current))
+	for _, image := range current {
+		existing[image.Id] = image.Key
+	}
+
+	var uploadErr error
+	gallery := []GalleryImage{}
+	for _, image := range parseMazeImages(data) {
+		if key, exists := existing[image.Id]; exists {
+			gallery = append(gallery, GalleryImage{Id: image.Id, Type: image.Type, Main: image.Main, Key: key})
+			delete(existing, image.Id)
+			continue
+		}
+		kind, exists := galleryImageKinds[image.Type]
+		if !exists {
+			kind = "Image"
+		}
+		properties := mediaProperties(kind, show.Name, show.Id)
+		uploaded, err := retryImage(8, 200*time.Millisecond, 10*time.Second, func() (uploadedImage, error) {
+			return createUmbImage(fmt.Sprintf("%s %s %d", show.Name, image.Type, image.Id), image.Source, folderId, properties)
+		})
+		if err != nil {
+			fmt.Printf("Failed to upload gallery image %d of show %d: %v\n", image.Id, show.Id, err)
+			uploadErr = err
+			continue
+		}
+		// createUmbImage returns no key and no error for images it skips (a failed download, too large...)
+		if uploaded.Key == "" {
+			fmt.Printf("Gallery image %d of show %d was not uploaded\n", image.Id, show.Id)
+			uploadErr = fmt.Errorf("gallery image %d was not uploaded", image.Id)
+			continue
+		}
+		gallery = append(gallery, GalleryImage{Id: image.Id, Type: image.Type, Main: image.Main, Key: uploaded.Key})
+	}
+
+	removed := []string{}
+	for _, key := range existing {
+		removed = append(removed, key)
+	}
+	return gallery, removed, uploadErr
+}
+
+// The show's images in gallery order: by type, and the main image of each type first
+func parseMazeImages(data []byte) []mazeImage {
+	images := []mazeImage{}
+	gjson.ParseBytes(data).ForEach(func(i, item gjson.Result) bool {
+		source := item.Get("resolutions." + config.ImageVariant + ".url").String()
+		if source == "" {
+			// Banners and backgrounds often only come in their original size
+			source = item.Get("resolutions.original.url").String()
+		}
+		if source == "" {
+			return true
+		}
+		images = append(images, mazeImage{
+			Id:     int(item.Get("id").Int()),
+			Type:   item.Get("type").String(),
+			Main:   item.Get("main").Bool(),
+			Source: source,
+		})
+		return true
+	})
+	sort.SliceStable(images, func(a, b int) bool {
+		aRank, bRank := galleryRank(images[a].Type), galleryRank(images[b].Type)
+		if aRank != bRank {
+			return aRank < bRank
+		}
+		return images[a].Main && !images[b].Main
+	})
+	return images
+}
+
+func galleryRank(imageType string) int {
+	if rank, exists := galleryTypeOrder[imageType]; exists {
+		return rank
+	}
+	return len(galleryTypeOrder)
+}
+
+// The gallery properties as JSON to add to a show body, in the style of showDetailsJson
+func showGalleryJson(show Show) (string, error) {
+	picker := []map[string]string{}
+	sources := []GalleryImage{}
+	for _, image := range show.Gallery {
+		picker = append(picker, map[string]string{"mediaKey": image.Key})
+		// Keys are only kept in the picker, which restore remaps
+		image.Key = ""
+		sources = append(sources, image)
+	}
+	source, err := json.Marshal(sources)
+	if err != nil {
+		return "", err
+	}
+	properties := []struct {
+		alias string
+		value interface{}
+	}{
+		{"showGallery", picker},
+		{"showGallerySource", string(source)},
+		{"galleryUpdated", show.GalleryUpdated},
+	}
+
+	var galleryJson strings.Builder
+	for _, property := range properties {
+		valueJson, err := json.Marshal(property.value)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&galleryJson, `,
+		"%s": {
+			"$invariant": %s
+		}`, property.alias, valueJson)
+	}
+	return galleryJson.String(), nil
+}
+
+// The TVMaze images in showGallerySource paired with the media keys in showGallery, which are in the same order.
+// When the two don't line up, e.g. after an editor removed an image, the gallery is read as empty and rebuilt
+func parseUmbGallery(umbShow gjson.Result) []GalleryImage {
+	gallery := []GalleryImage{}
+	source := umbShow.Get("showGallerySource.$invariant").String()
+	if source == "" {
+		return gallery
+	}
+	if err := json.Unmarshal([]byte(source), &gallery); err != nil {
+		fmt.Printf("Unable to read the gallery of node %s: %v\n", umbShow.Get("_id").String(), err)
+		return []GalleryImage{}
+	}
+	keys := umbShow.Get("showGallery.$invariant.#.mediaKey").Array()
+	if len(keys) != len(gallery) {
+		fmt.Printf("Gallery of node %s doesn't match showGallerySource, it will be rebuilt\n", umbShow.Get("_id").String())
+		return []GalleryImage{}
+	}
+	for i := range gallery {
+		gallery[i].Key = keys[i].String()
+	}
+	return gallery
+}
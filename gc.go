@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func runGC(args []string) {
+	if len(args) == 0 || args[0] != "media" {
+		printUsage()
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("gc media", flag.ExitOnError)
+	report := flags.Bool("report", false, "Only list the orphaned media, don't delete it")
+	minAge := flags.Duration("min-age", time.Hour, "Skip media younger than this, it may belong to a run still in progress")
+	yes := flags.Bool("yes", false, "Don't ask for confirmation")
+	workers := flags.Int("workers", WORKER_COUNT, "Number of concurrent deletes")
+	logPath := flags.String("log", fmt.Sprintf("gc-%s.jsonl", time.Now().Format("20060102-150405")), "Deletion log")
+	flags.Parse(args[1:])
+	if *workers < 1 {
+		fmt.Println("--workers must be at least 1")
+		os.Exit(2)
+	}
+
+	if !initUmbRoot(&http.Client{}) {
+		return
+	}
+	fmt.Println("Downloading shows and media...")
+	content, err := getAllUmbContent()
+	if err != nil {
+		fmt.Println("Unable to fetch umb shows:", err)
+		os.Exit(1)
+	}
+	childMedia, err := getUmbEpisodeMedia(content)
+	if err != nil {
+		fmt.Println("Unable to fetch umb seasons and episodes:", err)
+		os.Exit(1)
+	}
+	keptMedia, err := getUmbPeopleMedia()
+	if err != nil {
+		fmt.Println("Unable to fetch umb people:", err)
+		os.Exit(1)
+	}
+	media, err := getAllUmbMedia()
+	if err != nil {
+		fmt.Println("Unable to fetch umb media:", err)
+		os.Exit(1)
+	}
+
+	targets := planMediaGC(content, childMedia, keptMedia, media, *minAge)
+	if *report {
+		for _, target := range targets {
+			fmt.Printf("Orphaned: %s %s\n", target.Id, target.Name)
+		}
+	}
+	if !confirmDeletion(targets, *yes || *report) {
+		fmt.Println("Aborted")
+		return
+	}
+	if err := executeDeletion(targets, *workers, *logPath, *report); err != nil {
+		fmt.Println("GC failed:", err)
+		os.Exit(1)
+	}
+}
+
+// Importer media that no show references, leaving out anything created in the last minAge
+func planMediaGC(content []gjson.Result, childMedia map[string][]string, keptMedia []string, media []gjson.Result, minAge time.Duration) []deleteTarget {
+	cutoff := time.Now().Add(-minAge)
+	oldMedia := []gjson.Result{}
+	for _, item := range media {
+		// Folders are never deleted, but importerMediaFolders needs the whole tree
+		created, err := parseUmbDate(item.Get("_createDate").String())
+		if err == nil && created.After(cutoff) && item.Get("mediaTypeAlias").String() != "Folder" {
+			continue
+		}
+		oldMedia = append(oldMedia, item)
+	}
+	return planDeletion(content, childMedia, keptMedia, oldMedia, deleteFilter{ImporterOnly: true, OrphanedOnly: true})
+}
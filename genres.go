@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+const GENRE_FOLDER_TYPE = "genreFolder"
+
+// Genre nodes, keyed by TVMaze's genre title. The title has its own property, so editors can rename the node
+var genreNodes = &ContentCollection{
+	FolderName: &config.GenreFolder,
+	FolderType: GENRE_FOLDER_TYPE,
+	NodeKey: func(node gjson.Result) string {
+		if node.Get("contentTypeAlias").String() != "genre" {
+			return ""
+		}
+		title := node.Get("genreTitle.$invariant").String()
+		if title == "" {
+			title = node.Get("name." + LANGUAGE).String()
+		}
+		return strings.ToLower(title)
+	},
+}
+
+// Value of the showGenres picker: the UDIs of the genres' nodes, comma separated. Creates the nodes that are missing
+func genreNodesFor(genres []Genre) (string, error) {
+	udis := []string{}
+	for _, genre := range genres {
+		id, err := genreNodes.Node(strings.ToLower(genre.Title), func() (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"contentTypeAlias": "genre",
+				"name":             map[string]string{LANGUAGE: genre.Title},
+				"genreTitle":       map[string]interface{}{"$invariant": genre.Title},
+			}, nil
+		})
+		if err != nil {
+			return "", err
+		}
+		udis = append(udis, umbDocumentUdi(id))
+	}
+	return strings.Join(udis, ","), nil
+}
+
+// UDI of the Genre node with this title, "" when there is none. Unlike genreNodesFor it never creates the folder or the node
+func findGenreNodeUdi(title string) (string, error) {
+	folderId, err := findUmbRootNode(GENRE_FOLDER_TYPE, config.GenreFolder)
+	if err != nil || folderId == "" {
+		return "", err
+	}
+	udi := ""
+	err = forEachUmbPage(config.UmbBaseURL+"content/"+folderId+"/children", "content", func(item gjson.Result) error {
+		if genreNodes.NodeKey(item) == strings.ToLower(title) {
+			udi = umbDocumentUdi(item.Get("_id").String())
+		}
+		return nil
+	})
+	return udi, err
+}
+
+// Moves the genres of every show from the genres block list to showGenres
+func runGenres(args []string) {
+	if len(args) == 0 || args[0] != "migrate" {
+		printUsage()
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("genres migrate", flag.ExitOnError)
+	flags.StringVar(&config.GenreFolder, "genre-folder", config.GenreFolder, "Name of the folder holding the genre nodes")
+	keepBlocks := flags.Bool("keep-blocks", false, "Leave the genres block list in place instead of clearing it")
+	dryRun := flags.Bool("dry-run", false, "Log the changes without making them")
+	workers := flags.Int("workers", WORKER_COUNT, "Number of concurrent updates")
+	flags.Parse(args[1:])
+	if *workers < 1 {
+		fmt.Println("--workers must be at least 1")
+		os.Exit(2)
+	}
+
+	if !initUmbRoot(&http.Client{}) {
+		return
+	}
+	fmt.Println("Downloading shows...")
+	content, err := getAllUmbContent()
+	if err != nil {
+		fmt.Println("Unable to fetch umb shows:", err)
+		os.Exit(1)
+	}
+
+	shows := []gjson.Result{}
+	for _, item := range content {
+		if item.Get("contentTypeAlias").String() == "tVShow" && item.Get("genres.$invariant.contentData.#").Int() > 0 {
+			shows = append(shows, item)
+		}
+	}
+	fmt.Printf("%d shows have block list genres\n", len(shows))
+
+	var mu sync.Mutex
+	migrated, failed := 0, 0
+	forEachConcurrent(shows, *workers, func(item gjson.Result) {
+		show := parseUmbShow(item)
+		err := migrateShowGenres(show, *keepBlocks, *dryRun)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			fmt.Printf("Failed to migrate the genres of show %d: %v\n", show.Id, err)
+			failed++
+			return
+		}
+		migrated++
+	})
+	fmt.Printf("Migrated %d shows, %d failed\n", migrated, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func migrateShowGenres(show Show, keepBlocks bool, dryRun bool) error {
+	titles := []string{}
+	for _, genre := range show.Genres {
+		titles = append(titles, genre.Title)
+	}
+	if dryRun {
+		fmt.Printf("Would set the genres of show %d (%s) to %s\n", show.Id, show.UmbId, strings.Join(titles, ", "))
+		return nil
+	}
+
+	genres, err := genreNodesFor(show.Genres)
+	if err != nil {
+		return err
+	}
+	// Only the properties that change, like the partial show PUTs when an option is off
+	node := map[string]interface{}{
+		"contentTypeAlias": "tVShow",
+		"parentId":         config.UmbRootItemId,
+		"name":             map[string]string{LANGUAGE: show.Name},
+		"showGenres":       map[string]interface{}{"$invariant": genres},
+	}
+	if !keepBlocks {
+		node["genres"] = map[string]interface{}{"$invariant": map[string]interface{}{"settingsData": []interface{}{}}}
+	}
+	umbLimiter.Wait()
+	return updateUmbContent(show.UmbId, node)
+}
@@ -3,10 +3,16 @@ module github.com/jonasbeltoft/heartcore_movie_import
 go 1.24.0
 
 require (
-	github.com/flytam/filenamify v1.2.0 // indirect
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/tidwall/gjson v1.18.0 // indirect
+	github.com/flytam/filenamify v1.2.0
+	github.com/google/uuid v1.6.0
+	github.com/joho/godotenv v1.5.1
+	github.com/tidwall/gjson v1.18.0
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/image v0.36.0
+)
+
+require (
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
 )
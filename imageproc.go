@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+)
+
+const DEFAULT_JPEG_QUALITY = 90 // Used when an image has to be re-encoded without --jpeg-quality
+
+// Whether any of the processing options are set, if not uploads are left untouched
+func processingImages() bool {
+	return config.ResizeMaxWidth > 0 || config.ResizeMaxHeight > 0 || config.JPEGQuality > 0 || config.StripMetadata
+}
+
+// Resizes, re-encodes and strips the image as configured. Returns the new bytes and what they are
+func processImage(data []byte, info imageInfo) ([]byte, imageInfo, error) {
+	if config.ResizeMaxWidth == 0 && config.ResizeMaxHeight == 0 && config.JPEGQuality == 0 {
+		// Only stripping, which doesn't need a re-encode
+		if config.StripMetadata && info.ContentType == "image/jpeg" {
+			stripped, err := stripJPEGMetadata(data)
+			return stripped, info, err
+		}
+		return data, info, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, info, err
+	}
+
+	width, height := fitWithin(img.Bounds().Dx(), img.Bounds().Dy(), config.ResizeMaxWidth, config.ResizeMaxHeight)
+	resized := width != img.Bounds().Dx() || height != img.Bounds().Dy()
+	if resized {
+		scaled := image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+		img = scaled
+	} else if info.ContentType != "image/jpeg" || config.JPEGQuality == 0 {
+		// Nothing to do, the quality only applies to JPEGs
+		if config.StripMetadata && info.ContentType == "image/jpeg" {
+			stripped, err := stripJPEGMetadata(data)
+			return stripped, info, err
+		}
+		return data, info, nil
+	}
+
+	// PNGs stay PNG to keep their transparency, everything else becomes a JPEG.
+	// Neither encoder writes metadata, so re-encoding strips it as well
+	var out bytes.Buffer
+	if info.ContentType == "image/png" {
+		err = png.Encode(&out, img)
+	} else {
+		quality := config.JPEGQuality
+		if quality == 0 {
+			quality = DEFAULT_JPEG_QUALITY
+		}
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: quality})
+		info.ContentType = "image/jpeg"
+		info.Extension = imageExtensions[info.ContentType]
+	}
+	if err != nil {
+		return nil, info, err
+	}
+
+	info.Width, info.Height = width, height
+	return out.Bytes(), info, nil
+}
+
+// Scales width x height down to fit within maxWidth x maxHeight, keeping the aspect ratio. A max of 0 is no limit
+func fitWithin(width int, height int, maxWidth int, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && float64(height)*scale > float64(maxHeight) {
+		scale = float64(maxHeight) / float64(height)
+	}
+	if scale == 1.0 {
+		return width, height
+	}
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
+
+// Removes the EXIF/XMP (APP1), IPTC (APP13) and comment segments from a JPEG without re-encoding it.
+// JFIF, ICC profiles and the Adobe segment are kept since they change how the image is displayed
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a JPEG")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		marker := data[pos+1]
+		if marker == 0xDA {
+			// Start of scan, the rest is image data
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+			out.Write(data[pos:end])
+		}
+		pos = end
+	}
+	out.Write(data[pos:])
+	return out.Bytes(), nil
+}
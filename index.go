@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Concurrency safe index of the shows in Umbraco, keyed by TVMaze ID.
+// Workers write created and updated shows back into it, so it stays current during a run
+type ShowIndex struct {
+	mu     sync.RWMutex
+	shows  map[int]Show
+	umbIds map[string]int
+	hashes map[int]string // showHash of each show as it is in Umbraco
+	store  *StateStore    // Optional, every Put is written through to it
+	locks  keyedMutex[int]
+}
+
+func NewShowIndex(shows map[int]Show) *ShowIndex {
+	index := &ShowIndex{
+		shows:  make(map[int]Show, len(shows)),
+		umbIds: make(map[string]int, len(shows)),
+		hashes: make(map[int]string, len(shows)),
+	}
+	for _, show := range shows {
+		index.Put(show)
+	}
+	return index
+}
+
+// Builds the index from the state store instead of downloading every show.
+// The shows only carry their IDs and image, the rest is covered by the stored hash
+func NewShowIndexFromStore(store *StateStore) (*ShowIndex, error) {
+	states, err := store.All()
+	if err != nil {
+		return nil, err
+	}
+	index := NewShowIndex(nil)
+	for id, state := range states {
+		index.Put(Show{Id: id, UmbId: state.UmbId, Image: state.MediaKey, ImageSource: state.ImageSource, Poster: state.PosterKey, PosterSource: state.PosterSource, ImageBlurhash: state.Blurhash, ImageColor: state.Color, EpisodesUpdated: state.EpisodesUpdated, Cast: state.Cast, CastUpdated: state.CastUpdated, Gallery: state.Gallery, GalleryUpdated: state.GalleryUpdated})
+		index.hashes[id] = state.SourceHash
+	}
+	index.store = store
+	return index, nil
+}
+
+// Writes every later Put through to the store
+func (i *ShowIndex) UseStore(store *StateStore) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.store = store
+}
+
+func (i *ShowIndex) Get(id int) (Show, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	show, exists := i.shows[id]
+	return show, exists
+}
+
+func (i *ShowIndex) Put(show Show) {
+	hash := showHash(show)
+	i.mu.Lock()
+	if old, exists := i.shows[show.Id]; exists && old.UmbId != show.UmbId {
+		delete(i.umbIds, old.UmbId)
+	}
+	i.shows[show.Id] = show
+	i.hashes[show.Id] = hash
+	if show.UmbId != "" {
+		i.umbIds[show.UmbId] = show.Id
+	}
+	store := i.store
+	i.mu.Unlock()
+
+	if store != nil {
+		state := ShowState{UmbId: show.UmbId, MediaKey: show.Image, ImageSource: show.ImageSource, PosterKey: show.Poster, PosterSource: show.PosterSource, Blurhash: show.ImageBlurhash, Color: show.ImageColor, EpisodesUpdated: show.EpisodesUpdated, Cast: show.Cast, CastUpdated: show.CastUpdated, Gallery: show.Gallery, GalleryUpdated: show.GalleryUpdated, SourceHash: hash, LastSync: time.Now().UTC()}
+		if err := store.Put(show.Id, state); err != nil {
+			fmt.Printf("Failed to save state of show %d: %v\n", show.Id, err)
+		}
+	}
+}
+
+// Forgets a show whose node is gone from Umbraco, so it is created again
+func (i *ShowIndex) Delete(id int) {
+	i.mu.Lock()
+	if old, exists := i.shows[id]; exists {
+		delete(i.umbIds, old.UmbId)
+	}
+	delete(i.shows, id)
+	delete(i.hashes, id)
+	store := i.store
+	i.mu.Unlock()
+
+	if store != nil {
+		if err := store.Delete(id); err != nil {
+			fmt.Printf("Failed to remove state of show %d: %v\n", id, err)
+		}
+	}
+}
+
+// showHash of the show as last seen in Umbraco
+func (i *ShowIndex) SourceHash(id int) string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.hashes[id]
+}
+
+// The index as state store records
+func (i *ShowIndex) States() map[int]ShowState {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	now := time.Now().UTC()
+	states := make(map[int]ShowState, len(i.shows))
+	for id, show := range i.shows {
+		states[id] = ShowState{UmbId: show.UmbId, MediaKey: show.Image, ImageSource: show.ImageSource, PosterKey: show.Poster, PosterSource: show.PosterSource, Blurhash: show.ImageBlurhash, Color: show.ImageColor, EpisodesUpdated: show.EpisodesUpdated, Cast: show.Cast, CastUpdated: show.CastUpdated, Gallery: show.Gallery, GalleryUpdated: show.GalleryUpdated, SourceHash: i.hashes[id], LastSync: now}
+	}
+	return states
+}
+
+func (i *ShowIndex) ByUmbId(umbId string) (Show, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	id, exists := i.umbIds[umbId]
+	if !exists {
+		return Show{}, false
+	}
+	return i.shows[id], true
+}
+
+func (i *ShowIndex) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.shows)
+}
+
+// Copy of every show, sorted by ID
+func (i *ShowIndex) All() []Show {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	shows := make([]Show, 0, len(i.shows))
+	for _, show := range i.shows {
+		shows = append(shows, show)
+	}
+	sort.Slice(shows, func(a, b int) bool { return shows[a].Id < shows[b].Id })
+	return shows
+}
+
+// Locks a single show ID until the returned func is called. Other IDs are not blocked
+func (i *ShowIndex) Lock(id int) func() {
+	return i.locks.Lock(id)
+}
+
+// Whether any show other than exceptId uses the media as its image, poster or in its gallery
+func (i *ShowIndex) MediaInUse(key string, exceptId int) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	for id, show := range i.shows {
+		if id != exceptId && slices.Contains(show.MediaKeys(), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Re-reads Umbraco after a run and reports where it disagrees with the index
+func verifyIndex(index *ShowIndex) {
+	fmt.Println("\nVerifying index against Umbraco...")
+	content, err := getAllUmbContent()
+	if err != nil {
+		fmt.Println("Unable to fetch umb shows:", err)
+		return
+	}
+
+	nodesPerShow := make(map[int]int)
+	umbIds := make(map[string]bool)
+	unindexed := []int{}
+	for _, item := range content {
+		show := parseUmbShow(item)
+		umbIds[show.UmbId] = true
+		nodesPerShow[show.Id]++
+		if _, exists := index.ByUmbId(show.UmbId); !exists {
+			unindexed = append(unindexed, show.Id)
+		}
+	}
+
+	missing := []int{}
+	for _, show := range index.All() {
+		if !umbIds[show.UmbId] {
+			missing = append(missing, show.Id)
+		}
+	}
+	duplicates := []int{}
+	for id, nodes := range nodesPerShow {
+		if nodes > 1 {
+			duplicates = append(duplicates, id)
+		}
+	}
+	sort.Ints(duplicates)
+
+	fmt.Printf("Index: %d shows. Umbraco: %d nodes\n", index.Len(), len(content))
+	fmt.Printf("In the index but not in Umbraco: %d %v\n", len(missing), firstIds(missing))
+	fmt.Printf("In Umbraco but not in the index: %d %v\n", len(unindexed), firstIds(unindexed))
+	fmt.Printf("Shows with more than one node: %d %v\n", len(duplicates), firstIds(duplicates))
+}
+
+func firstIds(ids []int) []int {
+	if len(ids) > 20 {
+		return ids[:20]
+	}
+	return ids
+}
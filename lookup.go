@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// The IDs lookup accepts, with the Umbraco property holding each and TVMaze's lookup parameter
+var externalIdKinds = map[string]struct {
+	Property  string
+	MazeParam string // Empty for the TVMaze ID itself
+}{
+	"imdb":    {"showImdbId", "imdb"},
+	"thetvdb": {"showTheTvdbId", "thetvdb"},
+	"tvrage":  {"showTvRageId", "tvrage"},
+	"tvmaze":  {"showId", ""},
+}
+
+func runLookup(args []string) {
+	flags := flag.NewFlagSet("lookup", flag.ExitOnError)
+	ids := map[string]*string{}
+	for _, kind := range []string{"imdb", "thetvdb", "tvrage", "tvmaze"} {
+		ids[kind] = flags.String(kind, "", fmt.Sprintf("Find the show with this %s ID", kind))
+	}
+	statePath := flags.String("state", STATE_FILE, "State store used to find the node of a TVMaze ID. Empty to search Umbraco")
+	scan := flags.Bool("scan", false, "Search every show in Umbraco instead of asking TVMaze which show it is")
+	flags.Parse(args)
+
+	kind, value := "", ""
+	for k, v := range ids {
+		if *v == "" {
+			continue
+		}
+		if kind != "" {
+			fmt.Println("Only one ID can be looked up at a time")
+			os.Exit(2)
+		}
+		kind, value = k, *v
+	}
+	if kind == "" {
+		fmt.Println("Missing the ID to look up, one of --imdb, --thetvdb, --tvrage or --tvmaze")
+		os.Exit(2)
+	}
+
+	if !initUmbRoot(&http.Client{}) {
+		return
+	}
+
+	shows, err := lookupUmbShows(kind, value, *statePath, *scan)
+	if err != nil {
+		fmt.Println("Lookup failed:", err)
+		os.Exit(1)
+	}
+	if len(shows) == 0 {
+		fmt.Printf("No show with %s ID %s\n", kind, value)
+		os.Exit(1)
+	}
+	for _, show := range shows {
+		showJson, _ := json.MarshalIndent(show, "", "  ")
+		fmt.Println(string(showJson))
+	}
+}
+
+// Finds the show nodes with the external ID. TVMaze knows which show has it, so normally only that show is read
+// from Umbraco. Every show is searched when scan is set, TVMaze doesn't know the ID, or the node has another one
+func lookupUmbShows(kind string, value string, statePath string, scan bool) ([]Show, error) {
+	if !scan {
+		showId, err := lookupMazeShowId(kind, value)
+		if err != nil && !errors.Is(err, errMazeEnd) {
+			return nil, err
+		}
+		if err == nil {
+			show, found, err := getUmbShowById(showId, statePath)
+			if err != nil {
+				return nil, err
+			}
+			// Nodes that weren't synced since the IDs were added don't have them yet
+			stored := umbExternalId(show, kind)
+			if found && (stored == "" || stored == value) {
+				return []Show{show}, nil
+			}
+		}
+		fmt.Println("Not found through TVMaze, searching all shows...")
+	}
+
+	property := externalIdKinds[kind].Property
+	shows := []Show{}
+	err := forEachUmbPage(config.UmbRootItemURL+"/children", "content", func(item gjson.Result) error {
+		if item.Get(property+".$invariant").String() == value {
+			shows = append(shows, parseUmbShow(item))
+		}
+		return nil
+	})
+	return shows, err
+}
+
+// Asks TVMaze which show has the external ID. Returns errMazeEnd when it doesn't know the ID
+func lookupMazeShowId(kind string, value string) (int, error) {
+	param := externalIdKinds[kind].MazeParam
+	if param == "" {
+		return strconv.Atoi(value)
+	}
+	// TVMaze redirects to the show, which http.Get follows
+	data, err := fetchMazeRetry(config.MazeLookupURL + param + "=" + url.QueryEscape(value))
+	if err != nil {
+		return 0, err
+	}
+	return int(gjson.GetBytes(data, "id").Int()), nil
+}
+
+// Reads the node of a TVMaze ID, found through the state store when there is one
+func getUmbShowById(showId int, statePath string) (Show, bool, error) {
+	if statePath != "" {
+		if _, err := os.Stat(statePath); err == nil {
+			store, err := OpenStateStore(statePath)
+			if err != nil {
+				return Show{}, false, err
+			}
+			state, found, err := store.Get(showId)
+			store.Close()
+			if err != nil {
+				return Show{}, false, err
+			}
+			if found {
+				node, err := umbGet(config.UmbBaseURL + "content/" + state.UmbId)
+				if err == nil {
+					return parseUmbShow(gjson.ParseBytes(node)), true, nil
+				}
+				fmt.Printf("Unable to read node %s from the state store, searching Umbraco: %v\n", state.UmbId, err)
+			}
+		}
+	}
+	return findUmbShow(showId, time.Time{})
+}
+
+// The show's external ID of this kind as a string, empty when it has none
+func umbExternalId(show Show, kind string) string {
+	switch kind {
+	case "imdb":
+		return show.ImdbId
+	case "thetvdb":
+		if show.TheTvdbId != 0 {
+			return strconv.Itoa(show.TheTvdbId)
+		}
+	case "tvrage":
+		if show.TvRageId != 0 {
+			return strconv.Itoa(show.TvRageId)
+		}
+	case "tvmaze":
+		return strconv.Itoa(show.Id)
+	}
+	return ""
+}
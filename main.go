@@ -1,15 +1,20 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,8 +30,21 @@ import (
 // Build command: go build -ldflags "-X main.UMB_PROJ_ALIAS=$UMB_PROJECT_ALIAS -X main.UMB_API_KEY=$API_KEY" -o uploader .
 
 var config = &Configs{
-	MazeBaseURL: "https://api.tvmaze.com/shows?page=",
-	UmbBaseURL:  "https://api.rainbowsrock.net/",
+	MazeBaseURL:      "https://api.tvmaze.com/shows?page=",
+	MazeShowURL:      "https://api.tvmaze.com/shows/",
+	MazeLookupURL:    "https://api.tvmaze.com/lookup/shows?",
+	UmbBaseURL:       "https://api.rainbowsrock.net/",
+	ImageVariant:     "medium",
+	MediaFolders:     "none",
+	MediaFolderRoot:  "TVMaze",
+	MaxImageSize:     20 << 20,
+	MediaAltText:     "{kind} of {name}",
+	MediaAttribution: "Image from TVMaze (https://www.tvmaze.com), licensed under CC BY-SA 4.0",
+	MediaSourceInfo:  true,
+	NetworkFolder:    "Networks",
+	PeopleFolder:     "People",
+	GenreMode:        "blocks",
+	GenreFolder:      "Genres",
 }
 
 var UMB_PROJ_ALIAS = os.Getenv("UMB_PROJECT_ALIAS")
@@ -38,35 +56,142 @@ const TOTAL_PAGES = 332 // Real number is 332
 const LANGUAGE = "en-US"
 
 func main() {
-	// Create an HTTP client
-	client := &http.Client{}
+	cmd, args := "sync", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "sync":
+		runSync(args)
+	case "tvmaze":
+		runTVMaze(args)
+	case "export":
+		runExport(args)
+	case "restore":
+		runRestore(args)
+	case "delete":
+		runDelete(args)
+	case "gc":
+		runGC(args)
+	case "dedupe":
+		runDedupe(args)
+	case "reindex":
+		runReindex(args)
+	case "lookup":
+		runLookup(args)
+	case "genres":
+		runGenres(args)
+	default:
+		fmt.Println("Unknown command:", cmd)
+		printUsage()
+		os.Exit(2)
+	}
+}
 
+func printUsage() {
+	fmt.Println(`Usage: uploader <command> [flags]
+
+Commands:
+  sync            Import TVMaze shows into Umbraco (default)
+  tvmaze dump     Download all TVMaze show pages into a local dump directory
+  export          Back up all Umbraco shows and media to a snapshot
+  restore         Recreate shows and media from a snapshot
+  delete          Delete shows and media, with filters and a deletion log
+  gc media        Delete images the importer uploaded that no show uses
+  dedupe          Find shows that exist more than once and keep only one of them
+  reindex         Rebuild the local state store from Umbraco
+  lookup          Find the Umbraco show with an IMDb, TheTVDB, TVRage or TVMaze ID
+  genres migrate  Move the genres of existing shows from the block list to Genre nodes`)
+}
+
+// Looks up the Umbraco root item and stores its URL and ID in the config
+func initUmbRoot(client *http.Client) bool {
 	rootUrl := getRootIdUrl(client)
 	if rootUrl == "" {
 		fmt.Println("Root URL is empty")
-		return
+		return false
 	}
 
 	config.UmbRootItemURL = rootUrl
 	_urlSplit := strings.Split(rootUrl, "/")
 	config.UmbRootItemId = _urlSplit[len(_urlSplit)-1]
+	return true
+}
+
+func runSync(args []string) {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	sourceSpec := flags.String("source", "tvmaze", "Where to read shows from: tvmaze or dump://<dir>")
+	verify := flags.Bool("verify", false, "Compare the index with Umbraco once the run is done")
+	statePath := flags.String("state", STATE_FILE, "Local state store of synced shows. Empty to always download every show from Umbraco")
+	flags.BoolVar(&config.DeleteReplacedImages, "delete-replaced-images", false, "Delete the old media when TVMaze changes a show's image")
+	flags.StringVar(&config.ImageVariant, "image-variant", config.ImageVariant, "TVMaze image to use for showImage: medium or original")
+	flags.BoolVar(&config.UploadPoster, "poster", false, "Also upload the original size image into showPoster")
+	flags.BoolVar(&config.DedupeMedia, "dedupe-media", false, "Reuse already uploaded media with identical bytes. Off by default: hashing needs each image in memory, without it uploads are streamed")
+	flags.StringVar(&config.MediaFolders, "media-folders", config.MediaFolders, "Upload images into folders by: none, letter, year or bucket")
+	flags.StringVar(&config.MediaFolderRoot, "media-folder-root", config.MediaFolderRoot, "Name of the top level media folder used with --media-folders")
+	flags.Int64Var(&config.MaxImageSize, "max-image-size", config.MaxImageSize, "Largest image in bytes that will be uploaded")
+	flags.IntVar(&config.ResizeMaxWidth, "max-width", 0, "Scale images down to at most this width before upload")
+	flags.IntVar(&config.ResizeMaxHeight, "max-height", 0, "Scale images down to at most this height before upload")
+	flags.IntVar(&config.JPEGQuality, "jpeg-quality", 0, "Re-encode JPEGs at this quality (1-100) before upload")
+	flags.BoolVar(&config.StripMetadata, "strip-metadata", false, "Remove EXIF, XMP and comments from JPEGs before upload")
+	flags.BoolVar(&config.ImagePlaceholders, "placeholders", false, "Store a BlurHash and the dominant colour of each show's image in imageBlurhash and imageColor")
+	flags.StringVar(&config.MediaAltText, "media-alt-text", config.MediaAltText, "Alt text of uploaded images. {kind} is the image's role (Poster, Banner, Still, Photo...), {name} what it shows and {id} the show's TVMaze ID. Left out of show images with --dedupe-media, since other shows can reuse them. Empty to leave it out")
+	flags.StringVar(&config.MediaAttribution, "media-attribution", config.MediaAttribution, "Copyright/attribution text of uploaded images. Empty to leave it out")
+	flags.BoolVar(&config.MediaSourceInfo, "media-source-info", config.MediaSourceInfo, "Store the TVMaze image URL and show ID on uploaded images")
+	flags.BoolVar(&config.ImportNetworks, "networks", false, "Keep Network and WebChannel nodes in a top level folder and link each show to its network")
+	flags.StringVar(&config.NetworkFolder, "network-folder", config.NetworkFolder, "Name of the folder holding the network nodes")
+	flags.BoolVar(&config.ImportEpisodes, "episodes", false, "Fetch the seasons and episodes of each show into Season and Episode nodes below it")
+	flags.BoolVar(&config.ImportCast, "cast", false, "Fetch each show's cast into showCast, with Person nodes in a shared top level folder")
+	flags.StringVar(&config.PeopleFolder, "people-folder", config.PeopleFolder, "Name of the folder holding the person nodes")
+	flags.StringVar(&config.CastContentTypeKey, "cast-element-type", config.CastContentTypeKey, "Key of the element type used for the showCast blocks")
+	flags.BoolVar(&config.ImportGallery, "gallery", false, "Upload every TVMaze image of each show into showGallery")
+	flags.StringVar(&config.GenreMode, "genre-mode", config.GenreMode, "How genres are stored: blocks (the genres block list) or taxonomy (Genre nodes picked in showGenres)")
+	flags.StringVar(&config.GenreFolder, "genre-folder", config.GenreFolder, "Name of the folder holding the genre nodes")
+	flags.Parse(args)
+
+	if config.ImageVariant != "medium" && config.ImageVariant != "original" {
+		fmt.Println("Unknown --image-variant:", config.ImageVariant)
+		os.Exit(2)
+	}
+	if config.JPEGQuality < 0 || config.JPEGQuality > 100 {
+		fmt.Println("--jpeg-quality must be between 1 and 100")
+		os.Exit(2)
+	}
+	if config.GenreMode != "blocks" && config.GenreMode != "taxonomy" {
+		fmt.Println("Unknown --genre-mode:", config.GenreMode)
+		os.Exit(2)
+	}
+	if config.ImportCast && config.CastContentTypeKey == "" {
+		fmt.Println("--cast needs the --cast-element-type of the showCast blocks")
+		os.Exit(2)
+	}
+	if _, exists := mediaFolderNames[config.MediaFolders]; !exists && config.MediaFolders != "none" {
+		fmt.Println("Unknown --media-folders:", config.MediaFolders)
+		os.Exit(2)
+	}
 
-	// https://docs.umbraco.com/umbraco-heartcore/api-documentation/content-management/content
-	// Get total items, iterate over all of them, and download to memory.
+	source, err := newMazeSource(*sourceSpec)
+	if err != nil {
+		fmt.Println("Unable to open source:", err)
+		os.Exit(1)
+	}
 
-	totalUmbShows := getUmbShowCount()
+	// Create an HTTP client
+	client := &http.Client{}
 
-	fmt.Println("Beginning umbraco download...")
-	allUmbShows := getAllUmbShows(totalUmbShows)
-	if allUmbShows == nil {
-		fmt.Println("Unable to fetch umb shows")
+	if !initUmbRoot(client) {
 		return
 	}
-	println("Total Umbraco shows fetched: ", len(allUmbShows))
 
-	// DELETION CODE COMMENT OUT TO DELETE ALL DATA FROM UMBRACO
-	// deleteAll(allUmbShows) // If duplicates are in the map, they wont be deleted and will need a second pass
-	// return
+	index, store, err := loadShowIndex(*statePath)
+	if err != nil {
+		fmt.Println("Unable to fetch umb shows:", err)
+		return
+	}
+	if store != nil {
+		defer store.Close()
+	}
 
 	// make Umbraco entries into hashmap based on ID
 	// Start fetching and uploading Maze movies.
@@ -82,13 +207,13 @@ func main() {
 	for i := 0; i < WORKER_COUNT; i++ {
 		go func() {
 			for page := range pageChan {
-				processPage(page, allUmbShows, &wg)
+				processPage(page, source, index, &wg)
 			}
 		}()
 	}
 
 	// Send pages to workers
-	for page := 0; page <= TOTAL_PAGES; page++ {
+	for page := 0; page <= source.LastPage(); page++ {
 		wg.Add(1)
 		pageChan <- page
 	}
@@ -98,173 +223,400 @@ func main() {
 
 	// Wait for all workers to finish
 	wg.Wait()
+
+	if *verify {
+		verifyIndex(index)
+	}
 }
 
-func deleteAll(allUmbShows map[int]Show) {
-	defer timeTrack(time.Now(), "Deletion of all shows and images")
-	client := &http.Client{Timeout: 60 * time.Second}
-	total := len(allUmbShows)
-	count := 0
-	for _, show := range allUmbShows {
-		fmt.Printf("Deleted %d of %d         \r", count, total)
-		count++
+// Builds the index from the state store when it has been filled before, otherwise from a full Umbraco download.
+// https://docs.umbraco.com/umbraco-heartcore/api-documentation/content-management/content
+func loadShowIndex(statePath string) (*ShowIndex, *StateStore, error) {
+	if statePath == "" {
+		fmt.Println("Beginning umbraco download...")
+		allUmbShows := getAllUmbShows(getUmbShowCount())
+		if allUmbShows == nil {
+			return nil, nil, fmt.Errorf("umbraco download failed")
+		}
+		println("Total Umbraco shows fetched: ", len(allUmbShows))
+		if config.DedupeMedia {
+			hashes, err := getUmbMediaHashes()
+			if err != nil {
+				return nil, nil, err
+			}
+			mediaHashes.Reset(hashes, nil)
+		}
+		return NewShowIndex(allUmbShows), nil, nil
+	}
 
-		url := fmt.Sprintf("%scontent/%s", config.UmbBaseURL, show.UmbId)
-		req, err := http.NewRequest("DELETE", url, nil)
+	store, err := OpenStateStore(statePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if store.Count() == 0 {
+		index, err := reindexState(store)
 		if err != nil {
-			fmt.Println("Error creating request:", err)
-			continue
+			store.Close()
+			return nil, nil, err
 		}
-		setAuthHeader(req)
+		index.UseStore(store)
+		return index, store, nil
+	}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Println("Error sending request:", err)
-			continue
+	index, err := NewShowIndexFromStore(store)
+	if err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	if err := mediaHashes.Load(store); err != nil {
+		store.Close()
+		return nil, nil, err
+	}
+	fmt.Printf("Loaded %d shows from %s\n", index.Len(), statePath)
+	if total := getUmbShowCount(); total != index.Len() {
+		fmt.Printf("Umbraco has %d nodes but the state store has %d shows. Run reindex if shows were changed outside the importer\n", total, index.Len())
+	}
+	return index, store, nil
+}
+
+func processPage(page int, source MazeSource, index *ShowIndex, wg *sync.WaitGroup) int {
+	defer timeTrack(time.Now(), fmt.Sprintf("Page %3d completed upload", page))
+	defer wg.Done()
+	mazePage, err := source.Page(page)
+	if err != nil {
+		println(err)
+		return 1
+	}
+	count := 1
+	for _, mazeShow := range mazePage {
+		processShow(mazeShow, index)
+		fmt.Printf("Page: %6d\tCount: %6d\tID: %6d\r", page, count, mazeShow.Id)
+		count++
+	}
+	return 0
+}
+
+// Creates or updates a single show and writes the result back into the index.
+// The show ID stays locked meanwhile, so a show seen twice in a run (TVMaze pages can shift) is only created once
+func processShow(mazeShow Show, index *ShowIndex) {
+	unlock := index.Lock(mazeShow.Id)
+	defer unlock()
+
+	folderId, err := mediaFolderFor(mazeShow)
+	if err != nil {
+		fmt.Printf("Failed to get media folder for show %d, using the media root: %v\n", mazeShow.Id, err)
+	}
+	var networkErr error
+	if config.ImportNetworks {
+		mazeShow.Network, networkErr = networkFor(mazeShow)
+		if networkErr != nil {
+			fmt.Printf("Failed to get network node for show %d: %v\n", mazeShow.Id, networkErr)
 		}
-		resp.Body.Close()
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("Failed to delete show %s, status code: %d\n", show.UmbId, resp.StatusCode)
+	var genresErr error
+	if config.GenreMode == "taxonomy" {
+		mazeShow.GenreNodes, genresErr = genreNodesFor(mazeShow.Genres)
+		if genresErr != nil {
+			fmt.Printf("Failed to get genre nodes for show %d: %v\n", mazeShow.Id, genresErr)
 		}
+	}
 
-		if show.Image == "" {
-			continue
+	// If it already is in umbraco
+	if umbShow, exists := index.Get(mazeShow.Id); exists {
+		// Keep the current network and genres rather than clearing them
+		if networkErr != nil {
+			mazeShow.Network = umbShow.Network
+		}
+		if genresErr != nil {
+			mazeShow.GenreNodes = umbShow.GenreNodes
+		}
+		updateShow(mazeShow, umbShow, index, folderId)
+		return
+	}
+	createShow(mazeShow, index, folderId)
+}
+
+// Brings a show that is already in Umbraco up to date, uploading only what changed
+func updateShow(mazeShow Show, umbShow Show, index *ShowIndex, folderId string) {
+	// Nothing changed since the last sync
+	// Shows without an image on TVMaze have all the images they can get
+	hasImages := (umbShow.Image != "" || mazeShow.ImageSource == "") && (!config.UploadPoster || umbShow.Poster != "" || mazeShow.PosterSource == "")
+	needsPlaceholder := config.ImagePlaceholders && umbShow.Image != "" && umbShow.ImageBlurhash == ""
+	episodesSynced := !config.ImportEpisodes || umbShow.EpisodesUpdated == mazeShow.Updated
+	castSynced := !config.ImportCast || umbShow.CastUpdated == mazeShow.Updated
+	gallerySynced := !config.ImportGallery || umbShow.GalleryUpdated == mazeShow.Updated
+	if hasImages && !needsPlaceholder && episodesSynced && castSynced && gallerySynced && index.SourceHash(mazeShow.Id) == showHash(mazeShow) {
+		return
+	}
+
+	// If no image is attached, or TVMaze has a new one, upload image
+	doUpload := false
+	replaced := []string{}
+	properties := mediaProperties("Poster", mazeShow.Name, mazeShow.Id)
+	image := updateShowImage(mazeShow.Name, folderId, umbShow.Image, umbShow.ImageSource, mazeShow.ImageSource, properties)
+	if image.Changed {
+		umbShow.Image, umbShow.ImageSource = image.Key, image.Source
+		doUpload = true
+	}
+	if image.Replaced != "" {
+		replaced = append(replaced, image.Replaced)
+	}
+	if config.ImagePlaceholders {
+		if image.Uploaded {
+			umbShow.ImageBlurhash, umbShow.ImageColor = image.Placeholder.Blurhash, image.Placeholder.Color
+			doUpload = true
+		} else if needsPlaceholder && umbShow.ImageSource != "" {
+			// Uploaded before placeholders were turned on
+			placeholder, err := downloadPlaceholder(umbShow.ImageSource)
+			if err != nil {
+				fmt.Printf("No placeholder for show %d: %v\n", mazeShow.Id, err)
+			} else {
+				umbShow.ImageBlurhash, umbShow.ImageColor = placeholder.Blurhash, placeholder.Color
+				doUpload = true
+			}
+		}
+	}
+	if config.UploadPoster {
+		poster := updateShowImage(mazeShow.Name+" poster", folderId, umbShow.Poster, umbShow.PosterSource, mazeShow.PosterSource, properties)
+		if poster.Changed {
+			umbShow.Poster, umbShow.PosterSource = poster.Key, poster.Source
+			doUpload = true
+		}
+		if poster.Replaced != "" {
+			replaced = append(replaced, poster.Replaced)
+		}
+	}
+	// Shows loaded from the state store have no fields to compare, the hash decides for them
+	if umbShow.Name != mazeShow.Name || umbShow.Summary != mazeShow.Summary || umbShow.ShowDetails != mazeShow.ShowDetails || umbShow.Network != mazeShow.Network || umbShow.GenreNodes != mazeShow.GenreNodes || index.SourceHash(mazeShow.Id) != showHash(mazeShow) {
+		umbShow.Name = mazeShow.Name
+		umbShow.Summary = mazeShow.Summary
+		umbShow.Genres = mazeShow.Genres
+		umbShow.ShowDetails = mazeShow.ShowDetails
+		umbShow.Network = mazeShow.Network
+		umbShow.GenreNodes = mazeShow.GenreNodes
+		doUpload = true
+	}
+	// TVMaze's updated time changes with the episodes too, so they are only fetched when it moved
+	if !episodesSynced {
+		if err := syncEpisodes(umbShow, folderId); err != nil {
+			fmt.Printf("Failed to sync episodes of show %d: %v\n", mazeShow.Id, err)
+		} else {
+			umbShow.EpisodesUpdated = mazeShow.Updated
+			doUpload = true
+		}
+	}
+	if !castSynced {
+		if cast, err := castFor(mazeShow); err != nil {
+			fmt.Printf("Failed to sync cast of show %d: %v\n", mazeShow.Id, err)
+		} else {
+			umbShow.Cast, umbShow.CastUpdated = cast, mazeShow.Updated
+			doUpload = true
 		}
-		url = fmt.Sprintf("%smedia/%s", config.UmbBaseURL, show.Image)
-		req, err = http.NewRequest("DELETE", url, nil)
+	}
+	if !gallerySynced {
+		gallery, removed, err := syncGallery(mazeShow, folderId, umbShow.Gallery)
+		if !slices.Equal(gallery, umbShow.Gallery) {
+			umbShow.Gallery = gallery
+			doUpload = true
+		}
+		replaced = append(replaced, removed...)
+		// Images that failed to upload are tried again next run
 		if err != nil {
-			fmt.Println("Error creating request:", err)
-			continue
+			fmt.Printf("Failed to sync gallery of show %d: %v\n", mazeShow.Id, err)
+		} else {
+			umbShow.GalleryUpdated = mazeShow.Updated
+			doUpload = true
 		}
-		setAuthHeader(req)
+	}
 
-		resp, err = client.Do(req)
+	if doUpload {
+		// The node can be gone or replaced when delete, dedupe or restore ran since the index was loaded
+		gone := false
+		err := retry(8, 200*time.Millisecond, 10*time.Second, func() error {
+			_, err := sendUmbShow("PUT", umbShow)
+			if isUmbStatus(err, http.StatusNotFound) {
+				gone = true
+				return nil
+			}
+			return err
+		})
+		if gone {
+			// Dedupe and restore leave the show under another _id, it is only created again when there is none
+			found, exists, err := findUmbShow(mazeShow.Id, time.Time{})
+			if err != nil {
+				fmt.Printf("Show %d is no longer in Umbraco as %s and looking it up failed: %v\n", mazeShow.Id, umbShow.UmbId, err)
+				return
+			}
+			if exists && found.UmbId == umbShow.UmbId {
+				fmt.Printf("Show %d is listed in Umbraco as %s but can't be updated\n", mazeShow.Id, umbShow.UmbId)
+				return
+			}
+			if exists {
+				fmt.Printf("Show %d moved in Umbraco from %s to %s, updating that node\n", mazeShow.Id, umbShow.UmbId, found.UmbId)
+				index.Put(found)
+				updateShow(mazeShow, found, index, folderId)
+				return
+			}
+			fmt.Printf("Show %d is no longer in Umbraco as %s, creating it again\n", mazeShow.Id, umbShow.UmbId)
+			index.Delete(mazeShow.Id)
+			createShow(mazeShow, index, folderId)
+			return
+		}
 		if err != nil {
-			fmt.Println("Error sending request:", err)
+			fmt.Println("Error when updating umbraco show", err)
+			return
+		}
+		index.Put(umbShow)
+	}
+	for _, key := range replaced {
+		// With deduplicated media other shows, or another image of this one, may still use it
+		if !config.DeleteReplacedImages || slices.Contains(umbShow.MediaKeys(), key) || index.MediaInUse(key, mazeShow.Id) {
 			continue
 		}
-		resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("Failed to delete image %s, status code: %d\n", show.UmbId, resp.StatusCode)
+		if _, err := umbSend("DELETE", config.UmbBaseURL+"media/"+key, nil); err != nil {
+			fmt.Printf("Failed to delete replaced image %s: %v\n", key, err)
+			continue
 		}
+		mediaHashes.DeleteKey(key)
 	}
-	req, err := http.NewRequest("GET", config.UmbBaseURL+"media", nil)
+}
+
+// Uploads the images of a show that isn't in Umbraco yet and creates its node, then the nodes below it
+func createShow(mazeShow Show, index *ShowIndex, folderId string) {
+	properties := mediaProperties("Poster", mazeShow.Name, mazeShow.Id)
+	image, err := retryImage(8, 200*time.Millisecond, 10*time.Second, func() (uploadedImage, error) {
+		return createUmbImage(mazeShow.Name, mazeShow.Image, folderId, properties)
+	})
 	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return
+		println("error when uploading image", err)
+		mazeShow.Image = ""
+	} else {
+		mazeShow.Image = image.Key
+		mazeShow.ImageBlurhash, mazeShow.ImageColor = image.Placeholder.Blurhash, image.Placeholder.Color
+	}
+	if config.UploadPoster {
+		poster, err := retryImage(8, 200*time.Millisecond, 10*time.Second, func() (uploadedImage, error) {
+			return createUmbImage(mazeShow.Name+" poster", mazeShow.PosterSource, folderId, properties)
+		})
+		if err != nil {
+			println("error when uploading poster", err)
+			mazeShow.Poster = ""
+		} else {
+			mazeShow.Poster = poster.Key
+		}
 	}
-	setAuthHeader(req)
 
-	// Send request
-	fmt.Println("Downloading all image ID's to delete")
-	resp, err := client.Do(req)
-	if err != nil || resp.StatusCode != 200 {
-		fmt.Printf("Error sending request. Status: %d\nError: %v", resp.StatusCode, err)
-		return
+	// Person nodes live in their own folder, so the cast can be set on the new show right away
+	if config.ImportCast {
+		if cast, err := castFor(mazeShow); err != nil {
+			fmt.Printf("Failed to sync cast of show %d: %v\n", mazeShow.Id, err)
+		} else {
+			mazeShow.Cast, mazeShow.CastUpdated = cast, mazeShow.Updated
+		}
 	}
-	defer resp.Body.Close()
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading response:", err)
-		return
+	if config.ImportGallery {
+		gallery, _, err := syncGallery(mazeShow, folderId, nil)
+		mazeShow.Gallery = gallery
+		if err != nil {
+			fmt.Printf("Failed to sync gallery of show %d: %v\n", mazeShow.Id, err)
+		} else {
+			mazeShow.GalleryUpdated = mazeShow.Updated
+		}
 	}
 
-	images := gjson.Get(string(body), "_embedded.media")
-	if !images.Exists() {
+	created, err := createUmbShow(mazeShow)
+	if err != nil {
+		fmt.Println("Error when creating umbraco show", err)
 		return
 	}
-	images.ForEach(func(i, umbShow gjson.Result) bool {
+	index.Put(created)
 
-		umbId := umbShow.Get("_id")
-		url := fmt.Sprintf("%smedia/%s", config.UmbBaseURL, umbId)
-		req, err := http.NewRequest("DELETE", url, nil)
-		if err != nil {
-			fmt.Println("Error creating request:", err)
-			return true
+	// The episodes go below the show, so it has to exist first
+	if config.ImportEpisodes {
+		if err := syncEpisodes(created, folderId); err != nil {
+			fmt.Printf("Failed to sync episodes of show %d: %v\n", created.Id, err)
+			return
 		}
-		setAuthHeader(req)
-
-		resp, err := client.Do(req)
+		created.EpisodesUpdated = created.Updated
+		err := retry(8, 200*time.Millisecond, 10*time.Second, func() error {
+			_, err := sendUmbShow("PUT", created)
+			return err
+		})
 		if err != nil {
-			fmt.Println("Error sending request:", err)
-			return true
+			fmt.Println("Error when updating umbraco show", err)
+			return
 		}
-		fmt.Println("Deleted img: ", umbId)
-		resp.Body.Close()
-		return true // keep iterating
-	})
+		index.Put(created)
+	}
 }
 
-func processPage(page int, allUmbShows map[int]Show, wg *sync.WaitGroup) int {
-	defer timeTrack(time.Now(), fmt.Sprintf("Page %3d completed upload", page))
-	defer wg.Done()
-	mazePage, err := getMazePage(config.MazeBaseURL + strconv.Itoa(page))
-	if err != nil {
-		println(err)
-		return 1
-	}
-	count := 1
-	for _, mazeShow := range mazePage {
-		// If it already is in umbraco
-		if umbShow, exists := allUmbShows[mazeShow.Id]; exists {
-			// If no image is attached, upload image
-			doUpload := false
-			if umbShow.Image == "" {
-				key, err := retryImage(8, 200*time.Millisecond, 10*time.Second, func() (string, error) {
-					return createUmbImage(mazeShow.Name, mazeShow.Image)
-				})
-				if err != nil {
-					println("error when uploading image", err)
-					umbShow.Image = ""
-				} else {
-					umbShow.Image = key
-					doUpload = true
-				}
-			}
-			// TODO Genres
-			if umbShow.Name != mazeShow.Name || umbShow.Summary != mazeShow.Summary {
-				umbShow.Name = mazeShow.Name
-				umbShow.Summary = mazeShow.Summary
-				doUpload = true
-			}
+type imageUpdate struct {
+	Key         string
+	Source      string
+	Replaced    string // Media key of the image this one replaces
+	Changed     bool
+	Uploaded    bool // Whether the image was downloaded again, Placeholder is only set then
+	Placeholder imagePlaceholder
+}
 
-			if doUpload {
-				err := retry(8, 200*time.Millisecond, 10*time.Second, func() error {
-					return sendUmbShow("PUT", umbShow)
-				})
-				if err != nil {
-					fmt.Println("Error when creating umbraco show", err)
-				}
-				fmt.Printf("Page: %6d\tCount: %6d\tID: %6d\r", page, count, mazeShow.Id)
-			} else {
-				fmt.Printf("Page: %6d\tCount: %6d\tID: %6d\r", page, count, mazeShow.Id)
-			}
-		} else {
-			key, err := retryImage(8, 200*time.Millisecond, 10*time.Second, func() (string, error) {
-				return createUmbImage(mazeShow.Name, mazeShow.Image)
-			})
-			if err != nil {
-				println("error when uploading image", err)
-				mazeShow.Image = ""
-			} else {
-				mazeShow.Image = key
+// Uploads the image at newSource when the show has none yet, or when TVMaze changed it since it was uploaded
+func updateShowImage(name string, folderId string, key string, source string, newSource string, properties map[string]interface{}) imageUpdate {
+	update := imageUpdate{Key: key, Source: source}
+	changed := key != "" && source != "" && newSource != "" && source != newSource
+	// Shows without an image on TVMaze have nothing to upload
+	if (key == "" && newSource != "") || changed {
+		uploaded, err := retryImage(8, 200*time.Millisecond, 10*time.Second, func() (uploadedImage, error) {
+			return createUmbImage(name, newSource, folderId, properties)
+		})
+		if err != nil {
+			println("error when uploading image", err)
+		} else if !changed || uploaded.Key != "" {
+			// Deduplicated media can give back the key we already have
+			if changed && uploaded.Key != key {
+				update.Replaced = key
 			}
+			update.Key, update.Source, update.Changed = uploaded.Key, newSource, true
+			update.Uploaded, update.Placeholder = true, uploaded.Placeholder
+		}
+	}
+	// Shows imported before the image source was stored take the current one
+	if update.Key != "" && update.Source == "" && newSource != "" {
+		update.Source = newSource
+		update.Changed = true
+	}
+	return update
+}
 
-			err = retry(8, 200*time.Millisecond, 10*time.Second, func() error {
-				return sendUmbShow("POST", mazeShow)
-			})
+// POSTs a new show. A failed POST may still have created the node (e.g. a client timeout),
+// so after a timeout, transport error or 5xx we check whether the show exists now and stop if it does
+// Returns the show with its new UmbId
+func createUmbShow(show Show) (Show, error) {
+	// Only a POST that may have gone through can have created the node. Umbraco's clock can be a little off from ours
+	maybeCreated := false
+	since := time.Now().Add(-time.Minute)
+	err := retry(8, 200*time.Millisecond, 10*time.Second, func() error {
+		if maybeCreated {
+			existing, found, err := findUmbShow(show.Id, since)
 			if err != nil {
-				fmt.Println("Error when creating umbraco show", err)
+				return err
+			}
+			if found {
+				fmt.Printf("Show %d was created by an earlier attempt as %s\n", show.Id, existing.UmbId)
+				show.UmbId = existing.UmbId
+				return nil
 			}
-			//fmt.Printf("Page: %6d\tCount: %6d\tID: %6d\r", page, count, mazeShow.Id)
 		}
-		count++
-	}
-	return 0
+		umbId, err := sendUmbShow("POST", show)
+		if err != nil {
+			maybeCreated = maybeCreated || umbMayHaveApplied(err)
+			return err
+		}
+		show.UmbId = umbId
+		return nil
+	})
+	return show, err
 }
 
 func retry(attempts int, initialDelay time.Duration, maxDelay time.Duration, fn func() error) error {
@@ -288,8 +640,9 @@ func retry(attempts int, initialDelay time.Duration, maxDelay time.Duration, fn
 
 	return fmt.Errorf("all %d retry attempts failed: %w", attempts, err)
 }
-func retryImage(attempts int, initialDelay time.Duration, maxDelay time.Duration, fn func() (string, error)) (string, error) {
+func retryImage[T any](attempts int, initialDelay time.Duration, maxDelay time.Duration, fn func() (T, error)) (T, error) {
 	var err error = nil
+	var empty T
 	delay := initialDelay
 
 	for i := 0; i < attempts; i++ {
@@ -307,7 +660,7 @@ func retryImage(attempts int, initialDelay time.Duration, maxDelay time.Duration
 		delay = time.Duration(math.Min(float64(delay*2), float64(maxDelay)))
 	}
 
-	return "", fmt.Errorf("all %d retry attempts failed: %w", attempts, err)
+	return empty, fmt.Errorf("all %d retry attempts failed: %w", attempts, err)
 }
 
 func getAllUmbShows(totalUmbShows int) map[int]Show {
@@ -358,14 +711,38 @@ func getMazePage(url string) ([]Show, error) {
 		fmt.Println("Error reading shows data:", err)
 		return shows, err
 	}
-	showsGJson := gjson.Parse(string(showsData))
+	return parseMazeShows(showsData), nil
+}
+
+// Parses a TVMaze shows page (a JSON array of shows) into Show structs
+func parseMazeShows(showsData []byte) []Show {
+	shows := []Show{}
+	showsGJson := gjson.ParseBytes(showsData)
 	if showsGJson.Exists() {
 		showsGJson.ForEach(func(i, show gjson.Result) bool {
 			_show := Show{}
 			_show.Id = int(show.Get("id").Int())
 			_show.Name = show.Get("name").String()
 			_show.Summary = show.Get("summary").String()
-			_show.Image = show.Get("image.medium").String()
+			_show.Premiered = show.Get("premiered").String()
+			_show.Ended = show.Get("ended").String()
+			_show.Status = show.Get("status").String()
+			_show.Rating = show.Get("rating.average").Float()
+			_show.Runtime = int(show.Get("runtime").Int())
+			_show.AverageRuntime = int(show.Get("averageRuntime").Int())
+			_show.Language = show.Get("language").String()
+			_show.Type = show.Get("type").String()
+			_show.OfficialSite = show.Get("officialSite").String()
+			_show.ImdbId = show.Get("externals.imdb").String()
+			_show.TheTvdbId = int(show.Get("externals.thetvdb").Int())
+			_show.TvRageId = int(show.Get("externals.tvrage").Int())
+			_show.MazeNetwork = parseMazeNetwork(show)
+			_show.Updated = show.Get("updated").Int()
+			_show.Image = show.Get("image." + config.ImageVariant).String()
+			_show.ImageSource = _show.Image
+			if config.UploadPoster {
+				_show.PosterSource = show.Get("image.original").String()
+			}
 			genres := show.Get("genres")
 			if genres.Exists() {
 				newGenres := []Genre{}
@@ -382,34 +759,24 @@ func getMazePage(url string) ([]Show, error) {
 			return true // Keep iterating
 		})
 	}
-	return shows, nil
+	return shows
 }
 
-// Returns the mediaKey of this new media image
-func createUmbImage(imgName string, imgUrl string) (string, error) {
+type uploadedImage struct {
+	Key         string // mediaKey of the new or reused media
+	Placeholder imagePlaceholder
+}
+
+// Uploads the image at imgUrl, or reuses an earlier upload of the same bytes. An empty folderId uploads to the media root.
+// properties are extra media properties, see mediaProperties
+func createUmbImage(imgName string, imgUrl string, folderId string, properties map[string]interface{}) (uploadedImage, error) {
 
 	imgName, err := filenamify.Filenamify(imgName, filenamify.Options{
 		Replacement: "_",
 	})
-	imgName += ".jpg"
 	if err != nil {
 		fmt.Println("Failed to convert image name to file name", err)
-		return "", err
-	}
-	// Define the metadata as a struct
-	metadata := map[string]interface{}{
-		"mediaTypeAlias": "Image",
-		"name":           imgName,
-		"umbracoFile": map[string]string{
-			"src": imgName,
-		},
-	}
-
-	// Convert metadata to JSON
-	metadataJSON, err := json.Marshal(metadata)
-	if err != nil {
-		fmt.Println("Error encoding JSON:", err)
-		return "", err
+		return uploadedImage{}, err
 	}
 
 	// Fetch the image from the URL
@@ -420,69 +787,157 @@ func createUmbImage(imgName string, imgUrl string) (string, error) {
 			resp.Body.Close()
 		}
 		if strings.Contains(err.Error(), "unsupported protocol scheme") {
-			return "", nil // Skip retry
+			return uploadedImage{}, nil // Skip retry
 		}
-		return "", err
+		return uploadedImage{}, err
 	}
 
 	if resp == nil {
 		fmt.Println("Failed to get response image")
-		return "", err
+		return uploadedImage{}, err
 	}
 
 	// Check if the request was successful
 	if resp.StatusCode != http.StatusOK {
 		fmt.Println("Failed to download image, status:", resp.Status)
 		resp.Body.Close()
-		return "", err
+		return uploadedImage{}, err
 	}
+	defer resp.Body.Close()
 
-	// Read the image data
-	imageData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading image data:", err)
-		resp.Body.Close()
-		return "", err
+	if resp.ContentLength > config.MaxImageSize {
+		fmt.Printf("Not uploading %s: %d bytes is over the limit\n", imgUrl, resp.ContentLength)
+		return uploadedImage{}, nil // Skip retry
 	}
-	resp.Body.Close()
 
-	// Create a buffer and a multipart writer
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	// Only the start of the image is buffered, enough to sniff the type and read the dimensions
+	body := bufio.NewReaderSize(&sizeLimitReader{r: resp.Body, n: config.MaxImageSize}, SNIFF_SIZE)
+	header, err := body.Peek(SNIFF_SIZE)
+	if errors.Is(err, errImageTooLarge) {
+		fmt.Printf("Not uploading %s: %v\n", imgUrl, err)
+		return uploadedImage{}, nil // Skip retry
+	}
+	if err != nil && err != io.EOF {
+		fmt.Println("Error reading image data:", err)
+		return uploadedImage{}, err
+	}
 
-	// Add the JSON metadata as a form field
-	metadataPart, err := writer.CreateFormField("content")
+	// The extension comes from the bytes, TVMaze serves PNG and WebP as well as JPEG
+	info, err := sniffImage(header)
 	if err != nil {
-		fmt.Println("Error creating metadata part:", err)
-		return "", err
+		fmt.Printf("Not uploading %s: %v\n", imgUrl, err)
+		return uploadedImage{}, nil // Skip retry, the same URL will keep returning it
 	}
-	_, err = metadataPart.Write(metadataJSON)
-	if err != nil {
-		fmt.Println("Error writing metadata:", err)
-		return "", err
+	// Hashing, processing and placeholders need the whole image, otherwise it is streamed straight into the upload
+	var imageData io.Reader = body
+	hash := ""
+	uploaded := uploadedImage{}
+	if config.DedupeMedia || processingImages() || config.ImagePlaceholders {
+		data, err := io.ReadAll(body)
+		if errors.Is(err, errImageTooLarge) {
+			fmt.Printf("Not uploading %s: %v\n", imgUrl, err)
+			return uploadedImage{}, nil // Skip retry
+		}
+		if err != nil {
+			fmt.Println("Error reading image data:", err)
+			return uploadedImage{}, err
+		}
+
+		if processingImages() {
+			processed, processedInfo, err := processImage(data, info)
+			if err != nil {
+				fmt.Printf("Uploading %s unprocessed: %v\n", imgUrl, err)
+			} else {
+				data, info = processed, processedInfo
+			}
+		}
+		imageData = bytes.NewReader(data)
+
+		if config.ImagePlaceholders {
+			uploaded.Placeholder, err = computePlaceholder(data)
+			if err != nil {
+				fmt.Printf("No placeholder for %s: %v\n", imgUrl, err)
+			}
+		}
+
+		// Reuse an earlier upload of the same bytes
+		if config.DedupeMedia {
+			hash = imageHash(data)
+			unlock := mediaHashes.Lock(hash)
+			defer unlock()
+			if key, exists := mediaHashes.Get(hash); exists {
+				uploaded.Key = key
+				return uploaded, nil
+			}
+		}
 	}
+	imgName += info.Extension
 
-	// Create the file field in the multipart form
-	filePart, err := writer.CreateFormFile("umbracoFile", imgName)
+	// Define the metadata as a struct
+	metadata := map[string]interface{}{
+		"mediaTypeAlias": "Image",
+		"name":           imgName,
+		"umbracoFile": map[string]string{
+			"src": imgName,
+		},
+	}
+	if info.Width > 0 {
+		metadata["umbracoWidth"] = info.Width
+		metadata["umbracoHeight"] = info.Height
+	}
+	if hash != "" {
+		metadata["imageHash"] = hash
+	}
+	for alias, value := range properties {
+		metadata[alias] = value
+	}
+	if config.MediaSourceInfo {
+		metadata["sourceUrl"] = imgUrl
+	}
+	if folderId != "" {
+		metadata["parentId"] = folderId
+	}
+
+	// Convert metadata to JSON
+	metadataJSON, err := json.Marshal(metadata)
 	if err != nil {
-		fmt.Println("Error creating file part:", err)
-		return "", err
+		fmt.Println("Error encoding JSON:", err)
+		return uploadedImage{}, err
 	}
 
-	// Write the image data to the file part
-	_, err = filePart.Write(imageData)
+	key, err := uploadUmbMedia(metadataJSON, imgName, info.ContentType, imageData)
+	if errors.Is(err, errImageTooLarge) {
+		fmt.Printf("Not uploading %s: %v\n", imgUrl, err)
+		return uploadedImage{}, nil // Skip retry
+	}
 	if err != nil {
-		fmt.Println("Error writing image data:", err)
-		return "", err
+		return uploadedImage{}, err
 	}
+	if hash != "" {
+		mediaHashes.Put(hash, key)
+	}
+	uploaded.Key = key
+	return uploaded, nil
+}
 
-	// Close the writer to finalize the multipart body
-	writer.Close()
+// Uploads a file to the Umbraco media library with the given JSON metadata. Returns the new mediaKey.
+// The multipart body is written while the request is sent, so data is never held in memory as a whole
+func uploadUmbMedia(metadataJSON []byte, fileName string, contentType string, data io.Reader) (string, error) {
+	bodyReader, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		err := writeUmbMedia(writer, metadataJSON, fileName, contentType, data)
+		bodyWriter.CloseWithError(err)
+		writeErr <- err
+	}()
 
 	// Create the request
-	req, err := http.NewRequest("POST", config.UmbBaseURL+"media", body)
+	req, err := http.NewRequest("POST", config.UmbBaseURL+"media", bodyReader)
 	if err != nil {
 		fmt.Println("Error creating request:", err)
+		bodyReader.Close()
 		return "", err
 	}
 
@@ -492,7 +947,18 @@ func createUmbImage(imgName string, imgUrl string) (string, error) {
 
 	// Send the request
 	client := &http.Client{}
-	resp, err = client.Do(req)
+	resp, err := client.Do(req)
+
+	// Unblocks the writer if Umbraco answered before reading the whole body
+	bodyReader.Close()
+	if wErr := <-writeErr; wErr != nil && !errors.Is(wErr, io.ErrClosedPipe) {
+		fmt.Println("Error writing upload:", wErr)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return "", wErr
+	}
+
 	if err != nil || resp == nil {
 		fmt.Println("Error sending request:", err)
 		return "", err
@@ -500,26 +966,151 @@ func createUmbImage(imgName string, imgUrl string) (string, error) {
 	defer resp.Body.Close()
 	// Read response body
 	respBody, err := io.ReadAll(resp.Body)
-	if err != nil || resp.StatusCode != 201 {
+	if err != nil {
 		//fmt.Println("Error reading umb image response:", err)
 		return "", err
 	}
+	if resp.StatusCode != 201 {
+		return "", umbStatusError{Status: resp.StatusCode}
+	}
 
 	return gjson.Get(string(respBody), "_id").String(), nil
 }
 
-func sendUmbShow(requestType string, show Show) error {
+// Writes the JSON metadata and the file into the multipart body
+func writeUmbMedia(writer *multipart.Writer, metadataJSON []byte, fileName string, contentType string, data io.Reader) error {
+	// Add the JSON metadata as a form field
+	metadataPart, err := writer.CreateFormField("content")
+	if err != nil {
+		return err
+	}
+	_, err = metadataPart.Write(metadataJSON)
+	if err != nil {
+		return err
+	}
+
+	// Create the file field in the multipart form
+	fileHeader := make(textproto.MIMEHeader)
+	fileHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="umbracoFile"; filename="%s"`, strings.ReplaceAll(fileName, `"`, "_")))
+	fileHeader.Set("Content-Type", contentType)
+	filePart, err := writer.CreatePart(fileHeader)
+	if err != nil {
+		return err
+	}
+
+	// Copy the image data into the file part
+	_, err = io.Copy(filePart, data)
+	if err != nil {
+		return err
+	}
+
+	// Close the writer to finalize the multipart body
+	return writer.Close()
+}
+
+// Returns the _id of the created or updated node
+func sendUmbShow(requestType string, show Show) (string, error) {
 	// TODO: Generate Genres json string
 	genreJson, err := genreFormatter(show.Genres)
 	if err != nil {
 		genreJson = ""
 	}
+	// In taxonomy mode the block list is left empty and showGenres picks the Genre nodes
+	genreNodesJson := ""
+	if config.GenreMode == "taxonomy" {
+		genreJson = ""
+		genreNodes := "null"
+		if show.GenreNodes != "" {
+			genreNodes = fmt.Sprintf(`"%s"`, show.GenreNodes)
+		}
+		genreNodesJson = fmt.Sprintf(`,
+		"showGenres": {
+			"$invariant": %s
+		}`, genreNodes)
+	}
 	imgJson := ""
 	if show.Image != "" {
 		imgJson = fmt.Sprintf(`{
 					"mediaKey": "%s"
 				}`, show.Image)
 	}
+	posterJson := ""
+	if config.UploadPoster {
+		posterMediaJson := ""
+		if show.Poster != "" {
+			posterMediaJson = fmt.Sprintf(`{
+					"mediaKey": "%s"
+				}`, show.Poster)
+		}
+		posterJson = fmt.Sprintf(`,
+		"showPoster": {
+			"$invariant": [
+				%s
+			]
+		},
+		"showPosterSource": {
+			"$invariant": "%s"
+		}`, posterMediaJson, strings.ReplaceAll(show.PosterSource, "\"", "\\\""))
+	}
+	detailsJson, err := showDetailsJson(show.ShowDetails)
+	if err != nil {
+		fmt.Println("Error encoding show details:", err)
+		return "", err
+	}
+	networkJson := ""
+	if config.ImportNetworks {
+		network := "null"
+		if show.Network != "" {
+			network = fmt.Sprintf(`"%s"`, show.Network)
+		}
+		networkJson = fmt.Sprintf(`,
+		"showNetwork": {
+			"$invariant": %s
+		}`, network)
+	}
+	episodesJson := ""
+	if config.ImportEpisodes {
+		episodesJson = fmt.Sprintf(`,
+		"episodesUpdated": {
+			"$invariant": %d
+		}`, show.EpisodesUpdated)
+	}
+	castJson := ""
+	if config.ImportCast {
+		blocks, err := castFormatter(show.Cast)
+		if err != nil {
+			fmt.Println("Error encoding cast:", err)
+			return "", err
+		}
+		castJson = fmt.Sprintf(`,
+		"showCast": {
+			"$invariant": {
+				%s
+				"settingsData": []
+			}
+		},
+		"castUpdated": {
+			"$invariant": %d
+		}`, blocks, show.CastUpdated)
+	}
+	galleryJson := ""
+	if config.ImportGallery {
+		galleryJson, err = showGalleryJson(show)
+		if err != nil {
+			fmt.Println("Error encoding gallery:", err)
+			return "", err
+		}
+	}
+	placeholderJson := ""
+	if config.ImagePlaceholders {
+		placeholderJson = fmt.Sprintf(`,
+		"imageBlurhash": {
+			"$invariant": "%s"
+		},
+		"imageColor": {
+			"$invariant": "%s"
+		}`, show.ImageBlurhash, show.ImageColor)
+	}
 	// Create JSON string with fmt.Sprintf
 	jsonData := fmt.Sprintf(`{
 		"parentId": "%s",
@@ -544,8 +1135,11 @@ func sendUmbShow(requestType string, show Show) error {
 			"$invariant": [
 				%s
 			]
-		}
-	}`, config.UmbRootItemId, LANGUAGE, strings.ReplaceAll(show.Name, "\"", "\\\""), genreJson, show.Id, LANGUAGE, strings.ReplaceAll(show.Summary, "\"", "\\\""), imgJson)
+		},
+		"showImageSource": {
+			"$invariant": "%s"
+		}%s%s%s%s%s%s%s%s
+	}`, config.UmbRootItemId, LANGUAGE, strings.ReplaceAll(show.Name, "\"", "\\\""), genreJson, show.Id, LANGUAGE, strings.ReplaceAll(show.Summary, "\"", "\\\""), imgJson, strings.ReplaceAll(show.ImageSource, "\"", "\\\""), detailsJson, genreNodesJson, networkJson, episodesJson, castJson, galleryJson, posterJson, placeholderJson)
 	url := ""
 	if requestType == "POST" {
 		url = config.UmbBaseURL + "content"
@@ -555,7 +1149,7 @@ func sendUmbShow(requestType string, show Show) error {
 	req, err := http.NewRequest(requestType, url, bytes.NewBuffer([]byte(jsonData)))
 	if err != nil {
 		fmt.Println("Error creating request:", err)
-		return err
+		return "", err
 	}
 	setAuthHeader(req)
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
@@ -567,21 +1161,30 @@ func sendUmbShow(requestType string, show Show) error {
 	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Printf("Error sending request. ID: %d. Error: %v\n", show.Id, err)
-		return err
+		return "", err
 	}
 
 	// Ensure response is valid before accessing StatusCode
 	if resp == nil {
 		fmt.Printf("Error: response is nil for ID: %d skipping...\n", show.Id)
-		return nil
+		return show.UmbId, nil
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 201 && resp.StatusCode != 200 {
 		fmt.Printf("Server error. ID: %d. Status: %d\n", show.Id, resp.StatusCode)
-		return fmt.Errorf("status error: %d", resp.StatusCode)
+		return "", umbStatusError{Status: resp.StatusCode}
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
 	}
-	return nil
+	umbId := gjson.GetBytes(respBody, "_id").String()
+	if umbId == "" {
+		umbId = show.UmbId
+	}
+	return umbId, nil
 }
 
 func genreFormatter(genres []Genre) (string, error) {
@@ -665,55 +1268,159 @@ func getUmbShowPage(url string) ([]Show, error) {
 		return result, nil
 	}
 	shows.ForEach(func(i, umbShow gjson.Result) bool {
-		show := Show{}
-
-		UmbId := umbShow.Get("_id")
-		show.UmbId = UmbId.String()
-
-		id := umbShow.Get("showId.$invariant")
-		if id.Exists() {
-			_id := id.String()
-			if _id != "" {
-				num, err := strconv.Atoi(_id)
-				if err == nil {
-					show.Id = num
-				}
+		result = append(result, parseUmbShow(umbShow))
+
+		return true // keep iterating
+	})
+
+	return result, nil
+}
+
+// Parses a single tVShow content node
+func parseUmbShow(umbShow gjson.Result) Show {
+	show := Show{}
+
+	UmbId := umbShow.Get("_id")
+	show.UmbId = UmbId.String()
+
+	id := umbShow.Get("showId.$invariant")
+	if id.Exists() {
+		_id := id.String()
+		if _id != "" {
+			num, err := strconv.Atoi(_id)
+			if err == nil {
+				show.Id = num
 			}
 		}
-		name := umbShow.Get(fmt.Sprintf("name.%s", LANGUAGE))
-		if name.Exists() {
-			if name.String() != "" {
-				show.Name = name.String()
-			}
+	}
+	name := umbShow.Get(fmt.Sprintf("name.%s", LANGUAGE))
+	if name.Exists() {
+		if name.String() != "" {
+			show.Name = name.String()
 		}
-		genres := umbShow.Get("genres.$invariant.contentData.#.title")
-		if genres.Exists() {
-			newGenres := []Genre{}
-			for i, val := range genres.Array() {
-				genre := Genre{
-					Index: i,
-					Title: val.String(),
-				}
-				newGenres = append(newGenres, genre)
+	}
+	genres := umbShow.Get("genres.$invariant.contentData.#.title")
+	if genres.Exists() {
+		newGenres := []Genre{}
+		for i, val := range genres.Array() {
+			genre := Genre{
+				Index: i,
+				Title: val.String(),
 			}
-			show.Genres = newGenres
-		}
-		summary := umbShow.Get(fmt.Sprintf("showSummary.%s.markup", LANGUAGE))
-		if summary.Exists() {
-			show.Summary = summary.String()
+			newGenres = append(newGenres, genre)
 		}
+		show.Genres = newGenres
+	}
+	summary := umbShow.Get(fmt.Sprintf("showSummary.%s.markup", LANGUAGE))
+	if summary.Exists() {
+		show.Summary = summary.String()
+	}
 
-		image := umbShow.Get("showImage.$invariant.0.mediaKey")
-		if image.Exists() {
-			show.Image = image.String()
-		}
+	image := umbShow.Get("showImage.$invariant.0.mediaKey")
+	if image.Exists() {
+		show.Image = image.String()
+	}
 
-		result = append(result, show)
+	imageSource := umbShow.Get("showImageSource.$invariant")
+	if imageSource.Exists() {
+		show.ImageSource = imageSource.String()
+	}
 
-		return true // keep iterating
-	})
+	poster := umbShow.Get("showPoster.$invariant.0.mediaKey")
+	if poster.Exists() {
+		show.Poster = poster.String()
+	}
 
-	return result, nil
+	posterSource := umbShow.Get("showPosterSource.$invariant")
+	if posterSource.Exists() {
+		show.PosterSource = posterSource.String()
+	}
+	show.ImageBlurhash = umbShow.Get("imageBlurhash.$invariant").String()
+	show.Network = umbShow.Get("showNetwork.$invariant").String()
+	show.GenreNodes = umbShow.Get("showGenres.$invariant").String()
+	show.EpisodesUpdated = umbShow.Get("episodesUpdated.$invariant").Int()
+	show.Cast = parseUmbCast(umbShow)
+	show.CastUpdated = umbShow.Get("castUpdated.$invariant").Int()
+	show.Gallery = parseUmbGallery(umbShow)
+	show.GalleryUpdated = umbShow.Get("galleryUpdated.$invariant").Int()
+
+	// Missing and null properties stay at the zero value, like in parseMazeShows
+	show.Premiered = umbDateOnly(umbShow.Get("showPremiered.$invariant").String())
+	show.Ended = umbDateOnly(umbShow.Get("showEnded.$invariant").String())
+	show.Status = umbShow.Get("showStatus.$invariant").String()
+	show.Rating = umbShow.Get("showRating.$invariant").Float()
+	show.Runtime = int(umbShow.Get("showRuntime.$invariant").Int())
+	show.AverageRuntime = int(umbShow.Get("showAverageRuntime.$invariant").Int())
+	show.Language = umbShow.Get("showLanguage.$invariant").String()
+	show.Type = umbShow.Get("showType.$invariant").String()
+	show.OfficialSite = umbShow.Get("showOfficialSite.$invariant").String()
+	show.ImdbId = umbShow.Get("showImdbId.$invariant").String()
+	show.TheTvdbId = int(umbShow.Get("showTheTvdbId.$invariant").Int())
+	show.TvRageId = int(umbShow.Get("showTvRageId.$invariant").Int())
+	show.ImageColor = umbShow.Get("imageColor.$invariant").String()
+
+	return show
+}
+
+// The ShowDetails properties as JSON to add to a show body. Zero values are sent as null to clear the property
+func showDetailsJson(details ShowDetails) (string, error) {
+	properties := []struct {
+		alias string
+		value interface{}
+	}{
+		{"showPremiered", details.Premiered},
+		{"showEnded", details.Ended},
+		{"showStatus", details.Status},
+		{"showRating", details.Rating},
+		{"showRuntime", details.Runtime},
+		{"showAverageRuntime", details.AverageRuntime},
+		{"showLanguage", details.Language},
+		{"showType", details.Type},
+		{"showOfficialSite", details.OfficialSite},
+		{"showImdbId", details.ImdbId},
+		{"showTheTvdbId", details.TheTvdbId},
+		{"showTvRageId", details.TvRageId},
+	}
+
+	var detailsJson strings.Builder
+	for _, property := range properties {
+		valueJson, err := json.Marshal(nullIfZero(property.value))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&detailsJson, `,
+		"%s": {
+			"$invariant": %s
+		}`, property.alias, valueJson)
+	}
+	return detailsJson.String(), nil
+}
+
+// nil for empty strings and zero numbers, so they are sent to Umbraco as null
+func nullIfZero(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+	case int:
+		if v == 0 {
+			return nil
+		}
+	case float64:
+		if v == 0 {
+			return nil
+		}
+	}
+	return value
+}
+
+// Date pickers come back as 2006-01-02T15:04:05, TVMaze only has the date
+func umbDateOnly(date string) string {
+	if len(date) > 10 {
+		return date[:10]
+	}
+	return date
 }
 
 func getUmbShowCount() int {
@@ -784,7 +1491,34 @@ type Configs struct {
 	UmbRootItemId  string `json:"root_id,omitempty"`
 	UmbRootItemURL string `json:"root_url,omitempty"`
 	MazeBaseURL    string `json:"maze_base_url,omitempty"`
+	MazeShowURL    string `json:"maze_show_url,omitempty"`
+	MazeLookupURL  string `json:"maze_lookup_url,omitempty"`
 	UmbBaseURL     string `json:"umb_base_url,omitempty"`
+
+	DeleteReplacedImages bool   `json:"delete_replaced_images,omitempty"` // Delete the old media when a show gets a new image
+	ImageVariant         string `json:"image_variant,omitempty"`          // TVMaze image used for showImage: medium or original
+	UploadPoster         bool   `json:"upload_poster,omitempty"`          // Also upload image.original into showPoster
+	DedupeMedia          bool   `json:"dedupe_media,omitempty"`           // Reuse media with the same bytes instead of uploading again
+	MediaFolders         string `json:"media_folders,omitempty"`          // How uploads are split into folders: none, letter, year or bucket
+	MediaFolderRoot      string `json:"media_folder_root,omitempty"`      // Top level media folder holding the others
+	MaxImageSize         int64  `json:"max_image_size,omitempty"`         // Images larger than this many bytes are not uploaded
+	ResizeMaxWidth       int    `json:"resize_max_width,omitempty"`       // Larger images are scaled down to fit, 0 for no limit
+	ResizeMaxHeight      int    `json:"resize_max_height,omitempty"`      // Same for the height
+	JPEGQuality          int    `json:"jpeg_quality,omitempty"`           // Re-encode JPEGs at this quality (1-100), 0 keeps the original bytes
+	StripMetadata        bool   `json:"strip_metadata,omitempty"`         // Remove EXIF, XMP and comments from JPEGs
+	ImagePlaceholders    bool   `json:"image_placeholders,omitempty"`     // Store a BlurHash and dominant colour of showImage on each show
+	MediaAltText         string `json:"media_alt_text,omitempty"`         // Template for the altText media property, see the media-alt-text flag. Empty to leave it out
+	MediaAttribution     string `json:"media_attribution,omitempty"`      // Value of the attribution media property. Empty to leave it out
+	MediaSourceInfo      bool   `json:"media_source_info,omitempty"`      // Set the sourceUrl and tvMazeShowId media properties
+	ImportNetworks       bool   `json:"import_networks,omitempty"`        // Link shows to Network and WebChannel nodes through showNetwork
+	NetworkFolder        string `json:"network_folder,omitempty"`         // Name of the top level content folder holding the network nodes
+	ImportEpisodes       bool   `json:"import_episodes,omitempty"`        // Create Season and Episode nodes below each show
+	ImportCast           bool   `json:"import_cast,omitempty"`            // Fill showCast with the cast, linking to Person nodes
+	PeopleFolder         string `json:"people_folder,omitempty"`          // Name of the top level content folder holding the person nodes
+	CastContentTypeKey   string `json:"cast_content_type_key,omitempty"`  // Element type of the showCast blocks
+	ImportGallery        bool   `json:"import_gallery,omitempty"`         // Upload the posters, banners and backgrounds into showGallery
+	GenreMode            string `json:"genre_mode,omitempty"`             // blocks: the genres block list, taxonomy: Genre nodes picked in showGenres
+	GenreFolder          string `json:"genre_folder,omitempty"`           // Name of the top level content folder holding the genre nodes
 }
 
 type Show struct {
@@ -793,7 +1527,64 @@ type Show struct {
 	Name    string  `json:"name,omitempty"`        // Found in umbraco: ~content.name.$invariant   found in TVMaze: name
 	Genres  []Genre `json:"genres,omitempty"`      // Found in TVMaze content body as array of strings (titles only): genres
 	Summary string  `json:"showSummary,omitempty"` // Found in umbraco: ~content.showSummary.en-US.markup	found in TVMaze: summary
-	Image   string  `json:"showImage,omitempty"`   // Found in umbraco (is a UID): ~content.showImage.$invariant.[].mediaKey   found in TVMaze (link): image.original
+	Image   string  `json:"showImage,omitempty"`   // Found in umbraco (is a UID): ~content.showImage.$invariant.[].mediaKey   found in TVMaze (link): image.medium or image.original, see Configs.ImageVariant
+	// TVMaze URL the image was uploaded from, used to notice when TVMaze changes it. Found in umbraco: ~content.showImageSource.$invariant
+	ImageSource string `json:"showImageSource,omitempty"`
+	// Full size poster, only with Configs.UploadPoster. Found in umbraco: ~content.showPoster.$invariant.[].mediaKey   found in TVMaze (link): image.original
+	Poster       string `json:"showPoster,omitempty"`
+	PosterSource string `json:"showPosterSource,omitempty"` // Found in umbraco: ~content.showPosterSource.$invariant
+	ShowDetails
+	// UDI of the Network or WebChannel node, only with Configs.ImportNetworks. Found in umbraco: ~content.showNetwork.$invariant   found in TVMaze: network or webChannel
+	Network     string       `json:"showNetwork,omitempty"`
+	MazeNetwork *mazeNetwork `json:"-"`
+	Updated     int64        `json:"-"` // Unix time of TVMaze's last change to the show or its episodes. found in TVMaze: updated
+	// Comma separated UDIs of the Genre nodes, only with Configs.GenreMode taxonomy. Found in umbraco: ~content.showGenres.$invariant
+	GenreNodes string `json:"showGenres,omitempty"`
+	// Updated as it was when the episodes were last synced, only with Configs.ImportEpisodes. Found in umbraco: ~content.episodesUpdated.$invariant
+	EpisodesUpdated int64 `json:"episodesUpdated,omitempty"`
+	// Cast block list, only with Configs.ImportCast. Found in TVMaze: /shows/{id}/cast
+	Cast        []CastMember `json:"showCast,omitempty"`
+	CastUpdated int64        `json:"castUpdated,omitempty"` // Updated as it was when the cast was last synced. Found in umbraco: ~content.castUpdated.$invariant
+	// Every image of the show, only with Configs.ImportGallery. Found in umbraco: ~content.showGallery.$invariant.[].mediaKey, with the
+	// TVMaze image IDs in ~content.showGallerySource.$invariant   found in TVMaze: /shows/{id}/images
+	Gallery        []GalleryImage `json:"showGallery,omitempty"`
+	GalleryUpdated int64          `json:"galleryUpdated,omitempty"` // Found in umbraco: ~content.galleryUpdated.$invariant
+	// Placeholders computed from the showImage bytes, only with Configs.ImagePlaceholders. Found in umbraco: ~content.imageBlurhash.$invariant and ~content.imageColor.$invariant
+	ImageBlurhash string `json:"imageBlurhash,omitempty"`
+	ImageColor    string `json:"imageColor,omitempty"` // #rrggbb
+}
+
+// Keys of the media the show references: its image, poster and gallery
+func (s Show) MediaKeys() []string {
+	keys := []string{}
+	for _, key := range []string{s.Image, s.Poster} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	for _, image := range s.Gallery {
+		keys = append(keys, image.Key)
+	}
+	return keys
+}
+
+// Extra TVMaze fields, each in its own invariant Umbraco property. Missing and null values are left
+// at the zero value and sent to Umbraco as null, so shows without them compare equal on every sync
+type ShowDetails struct {
+	Premiered      string  `json:"showPremiered,omitempty"`      // YYYY-MM-DD. Found in umbraco: ~content.showPremiered.$invariant   found in TVMaze: premiered
+	Ended          string  `json:"showEnded,omitempty"`          // YYYY-MM-DD. found in TVMaze: ended
+	Status         string  `json:"showStatus,omitempty"`         // found in TVMaze: status, e.g. Running or Ended
+	Rating         float64 `json:"showRating,omitempty"`         // found in TVMaze: rating.average
+	Runtime        int     `json:"showRuntime,omitempty"`        // Minutes. found in TVMaze: runtime
+	AverageRuntime int     `json:"showAverageRuntime,omitempty"` // Minutes. found in TVMaze: averageRuntime
+	Language       string  `json:"showLanguage,omitempty"`       // found in TVMaze: language
+	Type           string  `json:"showType,omitempty"`           // found in TVMaze: type, e.g. Scripted or Reality
+	OfficialSite   string  `json:"showOfficialSite,omitempty"`   // found in TVMaze: officialSite
+
+	// IDs of the show on other sites, see the lookup command
+	ImdbId    string `json:"showImdbId,omitempty"`    // e.g. tt0944947. Found in umbraco: ~content.showImdbId.$invariant   found in TVMaze: externals.imdb
+	TheTvdbId int    `json:"showTheTvdbId,omitempty"` // found in TVMaze: externals.thetvdb
+	TvRageId  int    `json:"showTvRageId,omitempty"`  // found in TVMaze: externals.tvrage
 }
 
 type Genre struct {
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// Image types we accept from TVMaze, with the extension the upload gets
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+const SNIFF_SIZE = 64 << 10 // Bytes read ahead of an upload to find the type and dimensions. JPEGs with a large EXIF block may need more, then the size is left out
+
+var errImageTooLarge = errors.New("image is over the size limit")
+
+type imageInfo struct {
+	ContentType string
+	Extension   string
+	Width       int // 0 when the header couldn't be decoded
+	Height      int
+}
+
+// Works out what the downloaded bytes are. Anything that isn't an image (e.g. an HTML error page) is an error
+func sniffImage(data []byte) (imageInfo, error) {
+	contentType := http.DetectContentType(data)
+	extension, isImage := imageExtensions[contentType]
+	if !isImage {
+		return imageInfo{}, fmt.Errorf("not an image: %s", contentType)
+	}
+
+	info := imageInfo{ContentType: contentType, Extension: extension}
+	if imgConfig, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		info.Width = imgConfig.Width
+		info.Height = imgConfig.Height
+	}
+	return info, nil
+}
+
+// What each TVMaze image type is called in the alt text
+var galleryImageKinds = map[string]string{"poster": "Poster", "banner": "Banner", "background": "Background", "typography": "Logo"}
+
+// Media properties describing an image and where it comes from, as configured. kind is the image's role, e.g.
+// Poster or Still, and subject what it shows, e.g. the show or episode name. showId is 0 for images not tied to a show
+func mediaProperties(kind string, subject string, showId int) map[string]interface{} {
+	properties := make(map[string]interface{})
+	// With --dedupe-media other shows can reuse the upload, so it mustn't describe one show
+	shared := config.DedupeMedia && showId != 0
+	if config.MediaAltText != "" && !shared {
+		id := ""
+		if showId != 0 {
+			id = strconv.Itoa(showId)
+		}
+		replacer := strings.NewReplacer("{kind}", kind, "{name}", subject, "{id}", id)
+		properties["altText"] = replacer.Replace(config.MediaAltText)
+	}
+	if config.MediaAttribution != "" {
+		properties["attribution"] = config.MediaAttribution
+	}
+	if config.MediaSourceInfo && showId != 0 && !shared {
+		properties["tvMazeShowId"] = showId
+	}
+	return properties
+}
+
+// Reads at most n bytes from r, after that it fails with errImageTooLarge instead of cutting the image short
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	// One byte past the limit is enough to know the image is too large
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errImageTooLarge
+	}
+	return n, err
+}
+
+// Uploaded images by content hash, so the same bytes (placeholders, re-runs) are only uploaded once
+var mediaHashes = NewMediaHashIndex()
+
+type MediaHashIndex struct {
+	mu       sync.RWMutex
+	keys     map[string]string // sha256 -> media key
+	verified map[string]bool   // Keys checked to still exist during this run
+	store    *StateStore       // Optional, every Put is written through to it
+	locks    keyedMutex[string]
+}
+
+func NewMediaHashIndex() *MediaHashIndex {
+	return &MediaHashIndex{keys: make(map[string]string), verified: make(map[string]bool)}
+}
+
+// Replaces the index with the hashes saved in the store and writes later changes through to it
+func (m *MediaHashIndex) Load(store *StateStore) error {
+	hashes, err := store.MediaHashes()
+	if err != nil {
+		return err
+	}
+	m.Reset(hashes, store)
+	return nil
+}
+
+// Replaces the index. store may be nil
+func (m *MediaHashIndex) Reset(hashes map[string]string, store *StateStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = hashes
+	m.verified = make(map[string]bool)
+	m.store = store
+}
+
+// Media key of an earlier upload with these bytes. The media is checked to still exist the first time it is reused
+func (m *MediaHashIndex) Get(hash string) (string, bool) {
+	m.mu.RLock()
+	key, exists := m.keys[hash]
+	verified := m.verified[hash]
+	m.mu.RUnlock()
+	if !exists || verified {
+		return key, exists
+	}
+
+	if _, err := umbGet(config.UmbBaseURL + "media/" + key); err != nil {
+		m.Delete(hash)
+		return "", false
+	}
+	m.mu.Lock()
+	m.verified[hash] = true
+	m.mu.Unlock()
+	return key, true
+}
+
+func (m *MediaHashIndex) Put(hash string, key string) {
+	m.mu.Lock()
+	m.keys[hash] = key
+	m.verified[hash] = true
+	store := m.store
+	m.mu.Unlock()
+	if store != nil {
+		if err := store.PutMediaHash(hash, key); err != nil {
+			fmt.Println("Failed to save media hash:", err)
+		}
+	}
+}
+
+func (m *MediaHashIndex) Delete(hash string) {
+	m.mu.Lock()
+	delete(m.keys, hash)
+	delete(m.verified, hash)
+	store := m.store
+	m.mu.Unlock()
+	if store != nil {
+		store.DeleteMediaHash(hash)
+	}
+}
+
+// Forgets every hash pointing at this media key, e.g. after it was deleted
+func (m *MediaHashIndex) DeleteKey(key string) {
+	m.mu.RLock()
+	hashes := []string{}
+	for hash, mediaKey := range m.keys {
+		if mediaKey == key {
+			hashes = append(hashes, hash)
+		}
+	}
+	m.mu.RUnlock()
+	for _, hash := range hashes {
+		m.Delete(hash)
+	}
+}
+
+// Locks a hash while its image is uploaded, so two workers with the same bytes don't both upload them
+func (m *MediaHashIndex) Lock(hash string) func() {
+	return m.locks.Lock(hash)
+}
+
+func imageHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Reads the imageHash property of every media item in Umbraco
+func getUmbMediaHashes() (map[string]string, error) {
+	fmt.Println("Downloading media hashes...")
+	hashes := make(map[string]string)
+	err := forEachUmbMedia(func(item gjson.Result) error {
+		if hash := item.Get("imageHash").String(); hash != "" {
+			hashes[hash] = item.Get("_id").String()
+		}
+		return nil
+	})
+	fmt.Printf("Found %d hashed media items\n", len(hashes))
+	return hashes, err
+}
+
+// Rebuilds the media hashes in the store from Umbraco
+func reindexMediaHashes(store *StateStore) error {
+	hashes, err := getUmbMediaHashes()
+	if err != nil {
+		return err
+	}
+	if err := store.ReplaceMediaHashes(hashes); err != nil {
+		return err
+	}
+	mediaHashes.Reset(hashes, store)
+	return nil
+}
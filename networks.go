@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+const NETWORK_FOLDER_TYPE = "networkFolder"
+
+// A TVMaze network (broadcaster) or web channel (streaming service)
+type mazeNetwork struct {
+	Kind         string // contentTypeAlias of the node: network or webChannel
+	Id           int    // TVMaze ID, networks and web channels each have their own
+	Name         string
+	Country      string // Empty for web channels that aren't tied to a country
+	CountryCode  string
+	Timezone     string
+	OfficialSite string
+}
+
+// Network and WebChannel nodes, keyed by kind and TVMaze ID
+var networkNodes = &ContentCollection{
+	FolderName: &config.NetworkFolder,
+	FolderType: NETWORK_FOLDER_TYPE,
+	NodeKey: func(node gjson.Result) string {
+		kind := node.Get("contentTypeAlias").String()
+		if kind != "network" && kind != "webChannel" {
+			return ""
+		}
+		return fmt.Sprintf("%s:%d", kind, node.Get("networkId.$invariant").Int())
+	},
+}
+
+// TVMaze's network of a show, falling back to its web channel. nil when it has neither
+func parseMazeNetwork(show gjson.Result) *mazeNetwork {
+	for _, kind := range []string{"network", "webChannel"} {
+		network := show.Get(kind)
+		if !network.IsObject() {
+			continue
+		}
+		return &mazeNetwork{
+			Kind:         kind,
+			Id:           int(network.Get("id").Int()),
+			Name:         network.Get("name").String(),
+			Country:      network.Get("country.name").String(),
+			CountryCode:  network.Get("country.code").String(),
+			Timezone:     network.Get("country.timezone").String(),
+			OfficialSite: network.Get("officialSite").String(),
+		}
+	}
+	return nil
+}
+
+// UDI of the show's network node for the showNetwork picker, creating the node when needed. "" when the show has no network
+func networkFor(show Show) (string, error) {
+	network := show.MazeNetwork
+	if network == nil {
+		return "", nil
+	}
+	key := fmt.Sprintf("%s:%d", network.Kind, network.Id)
+	id, err := networkNodes.Node(key, func() (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"contentTypeAlias": network.Kind,
+			"name":             map[string]string{LANGUAGE: network.Name},
+			"networkId":        map[string]interface{}{"$invariant": network.Id},
+			"country":          map[string]interface{}{"$invariant": network.Country},
+			"countryCode":      map[string]interface{}{"$invariant": network.CountryCode},
+			"timezone":         map[string]interface{}{"$invariant": network.Timezone},
+			"officialSite":     map[string]interface{}{"$invariant": network.OfficialSite},
+		}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return umbDocumentUdi(id), nil
+}
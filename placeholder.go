@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const BLURHASH_X_COMPONENTS = 4
+const BLURHASH_Y_COMPONENTS = 3
+const PLACEHOLDER_SAMPLE_SIZE = 32 // Images are scaled down to this width before the placeholders are computed
+
+// Blurred preview and main colour of an image, shown by the site while the real one loads
+type imagePlaceholder struct {
+	Blurhash string
+	Color    string // #rrggbb
+}
+
+func computePlaceholder(data []byte) (imagePlaceholder, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return imagePlaceholder{}, err
+	}
+
+	// Both only need a rough picture, so they work on a small copy
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return imagePlaceholder{}, fmt.Errorf("empty image")
+	}
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), PLACEHOLDER_SAMPLE_SIZE, PLACEHOLDER_SAMPLE_SIZE)
+	sample := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(sample, sample.Bounds(), img, bounds, draw.Src, nil)
+
+	return imagePlaceholder{
+		Blurhash: blurhash(sample, BLURHASH_X_COMPONENTS, BLURHASH_Y_COMPONENTS),
+		Color:    dominantColor(sample),
+	}, nil
+}
+
+// Downloads an image only to compute its placeholders, for shows whose image was uploaded before they were computed
+func downloadPlaceholder(imgUrl string) (imagePlaceholder, error) {
+	resp, err := http.Get(imgUrl)
+	if err != nil {
+		return imagePlaceholder{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return imagePlaceholder{}, fmt.Errorf("status error: %d", resp.StatusCode)
+	}
+
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(&sizeLimitReader{r: resp.Body, n: config.MaxImageSize}); err != nil {
+		return imagePlaceholder{}, err
+	}
+	return computePlaceholder(data.Bytes())
+}
+
+// Most common colour, with the channels bucketed to 4 bits so near identical shades count together
+func dominantColor(img *image.NRGBA) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[int]*bucket)
+	var best *bucket
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A < 128 {
+				continue // Transparent areas aren't part of the picture
+			}
+			key := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			b, exists := buckets[key]
+			if !exists {
+				b = &bucket{}
+				buckets[key] = b
+			}
+			b.count++
+			b.r += int(c.R)
+			b.g += int(c.G)
+			b.b += int(c.B)
+			if best == nil || b.count > best.count {
+				best = b
+			}
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
+
+// Encodes the image as a BlurHash (https://blurha.sh) with xComponents x yComponents cosine components
+func blurhash(img *image.NRGBA, xComponents int, yComponents int) string {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+			var r, g, b float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) * math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					c := img.NRGBAAt(x, y)
+					r += basis * srgbToLinear(c.R)
+					g += basis * srgbToLinear(c.G)
+					b += basis * srgbToLinear(c.B)
+				}
+			}
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maximumValue := 1.0
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, factor := range factors[1:] {
+			for _, v := range factor {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maximumValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encode83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encode83(linearToSrgb(dc[0])<<16+linearToSrgb(dc[1])<<8+linearToSrgb(dc[2]), 4))
+	for _, factor := range factors[1:] {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quant(factor[0])*19*19+quant(factor[1])*19+quant(factor[2]), 2))
+	}
+	return hash.String()
+}
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encode83(value int, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(out)
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value float64, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func solidImage(width int, height int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: c}, image.Point{}, draw.Src)
+	return img
+}
+
+// Expected hashes are from a port of the reference encoder (github.com/woltapp/blurhash, TypeScript encode.ts)
+// run on the same pixels. Even a solid image gets small AC components, the sampled cosines don't cancel out
+func TestBlurhash(t *testing.T) {
+	gradient := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 10), B: 128, A: 0xff})
+		}
+	}
+	tests := []struct {
+		name string
+		img  *image.NRGBA
+		want string
+	}{
+		{"black", solidImage(32, 24, color.Black), "L00000fQfQfQfQfQfQfQfQfQfQfQ"},
+		{"white", solidImage(32, 24, color.White), "LDTSUA_3fQ_3~qoffQoffQfQfQfQ"},
+		{"gradient", gradient, "LxH27k2swxX8mHWWjtf7gJfjfQfj"},
+	}
+	for _, test := range tests {
+		got := blurhash(test.img, BLURHASH_X_COMPONENTS, BLURHASH_Y_COMPONENTS)
+		if got != test.want {
+			t.Errorf("%s: blurhash = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestComputePlaceholder(t *testing.T) {
+	var data bytes.Buffer
+	if err := png.Encode(&data, solidImage(100, 140, color.NRGBA{R: 0x33, G: 0x66, B: 0x99, A: 0xff})); err != nil {
+		t.Fatal(err)
+	}
+	placeholder, err := computePlaceholder(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(placeholder.Blurhash) != 28 || !strings.HasPrefix(placeholder.Blurhash, "L") {
+		t.Errorf("blurhash %s isn't a 4x3 hash", placeholder.Blurhash)
+	}
+	if placeholder.Color != "#336699" {
+		t.Errorf("color = %s, want #336699", placeholder.Color)
+	}
+
+	if _, err := computePlaceholder([]byte("<html>not an image</html>")); err == nil {
+		t.Error("computePlaceholder accepted a non image")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "time"
+
+// TVMaze allows 20 calls every 10 seconds per IP
+var mazeLimiter = newRateLimiter(20, 10*time.Second)
+
+// Used by the bulk commands (delete, gc...) so they don't trip the Umbraco rate limit when run concurrently
+var umbLimiter = newRateLimiter(10, time.Second)
+
+// Simple token bucket. Holds up to n tokens and refills one every per/n
+type rateLimiter struct {
+	tokens chan struct{}
+}
+
+func newRateLimiter(n int, per time.Duration) *rateLimiter {
+	limiter := &rateLimiter{tokens: make(chan struct{}, n)}
+	for i := 0; i < n; i++ {
+		limiter.tokens <- struct{}{}
+	}
+	go func() {
+		ticker := time.NewTicker(per / time.Duration(n))
+		defer ticker.Stop()
+		for range ticker.C {
+			select {
+			case limiter.tokens <- struct{}{}:
+			default: // Bucket is full
+			}
+		}
+	}()
+	return limiter
+}
+
+// Blocks until a request may be sent
+func (r *rateLimiter) Wait() {
+	<-r.tokens
+}
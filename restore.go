@@ -0,0 +1,492 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Something from the snapshot that could not be recreated
+type RestoreFailure struct {
+	Kind   string `json:"kind"` // content, media or file
+	Id     string `json:"id"`   // Id in the snapshot
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Properties Umbraco computes itself when a file is uploaded
+var computedMediaProperties = []string{"umbracoWidth", "umbracoHeight", "umbracoBytes", "umbracoExtension"}
+
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	from := flags.String("from", "", "Snapshot made by export, either a .tar.gz or a directory")
+	parentId := flags.String("parent", "", "Content node to restore the shows under. Defaults to the root item")
+	reportPath := flags.String("report", "", "Write the list of items that could not be restored to this JSON file")
+	flags.Parse(args)
+
+	if *from == "" {
+		fmt.Println("restore needs --from")
+		os.Exit(2)
+	}
+	if !initUmbRoot(&http.Client{}) {
+		return
+	}
+	if *parentId == "" {
+		*parentId = config.UmbRootItemId
+	}
+
+	failures, err := restoreSnapshot(*from, *parentId)
+	if err != nil {
+		fmt.Println("Restore failed:", err)
+		os.Exit(1)
+	}
+	for _, failure := range failures {
+		fmt.Printf("Not restored: %s %s (%s): %s\n", failure.Kind, failure.Id, failure.Name, failure.Reason)
+	}
+	fmt.Printf("%d items could not be restored\n", len(failures))
+	if *reportPath != "" {
+		if err := writeJSONFile(*reportPath, failures); err != nil {
+			fmt.Println("Failed to write report:", err)
+		}
+	}
+}
+
+func restoreSnapshot(from string, parentId string) ([]RestoreFailure, error) {
+	defer timeTrack(time.Now(), "Restore")
+	dir, cleanup, err := openSnapshot(from)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	manifest := SnapshotManifest{}
+	manifestData, err := os.ReadFile(filepath.Join(dir, SNAPSHOT_MANIFEST))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	r := &restorer{dir: dir, mediaKeys: make(map[string]string), contentIds: make(map[string]string), files: make(map[string]string)}
+	contentFiles := []string{}
+	mediaFiles := []string{}
+	for _, file := range manifest.Files {
+		if !r.verify(file) {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(file.Name, "content/"):
+			contentFiles = append(contentFiles, file.Name)
+		case strings.HasPrefix(file.Name, "media/files/"):
+			mediaId := strings.Split(file.Name, "/")[2]
+			r.files[mediaId] = file.Name
+		case strings.HasPrefix(file.Name, "media/"):
+			mediaFiles = append(mediaFiles, file.Name)
+		}
+	}
+
+	fmt.Println("Restoring media...")
+	if err := r.restoreMedia(mediaFiles); err != nil {
+		return r.failures, err
+	}
+	fmt.Println("Restoring content...")
+	r.restoreContent(contentFiles, parentId)
+	return r.failures, nil
+}
+
+type restorer struct {
+	dir        string
+	files      map[string]string // Old media id -> binary in the snapshot
+	mediaKeys  map[string]string // Old media id -> new media id
+	contentIds map[string]string // Old content id -> new content id
+	failures   []RestoreFailure
+	mu         sync.Mutex
+}
+
+func (r *restorer) fail(kind string, id string, name string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, RestoreFailure{Kind: kind, Id: id, Name: name, Reason: reason})
+}
+
+func (r *restorer) verify(file SnapshotFile) bool {
+	data, err := os.ReadFile(filepath.Join(r.dir, filepath.FromSlash(file.Name)))
+	if err != nil {
+		r.fail("file", file.Name, "", err.Error())
+		return false
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != file.SHA256 {
+		r.fail("file", file.Name, "", "checksum mismatch")
+		return false
+	}
+	return true
+}
+
+func (r *restorer) readJSON(name string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	item := map[string]interface{}{}
+	return item, json.Unmarshal(data, &item)
+}
+
+// Reads the snapshot items, by their _id. Unreadable files are reported as failures of kind
+func (r *restorer) readItems(kind string, names []string) map[string]map[string]interface{} {
+	items := make(map[string]map[string]interface{})
+	for _, name := range names {
+		item, err := r.readJSON(name)
+		if err != nil {
+			r.fail(kind, name, "", err.Error())
+			continue
+		}
+		id, _ := item["_id"].(string)
+		items[id] = item
+	}
+	return items
+}
+
+// The item ids grouped by how many of their ancestors are in items, so each level can be restored after its parents
+func restoreLevels(items map[string]map[string]interface{}) [][]string {
+	depth := func(id string) int {
+		d := 0
+		for parent, _ := items[id]["parentId"].(string); items[parent] != nil && d < len(items); parent, _ = items[parent]["parentId"].(string) {
+			d++
+		}
+		return d
+	}
+	levels := [][]string{}
+	for id := range items {
+		d := depth(id)
+		for len(levels) <= d {
+			levels = append(levels, []string{})
+		}
+		levels[d] = append(levels[d], id)
+	}
+	for _, level := range levels {
+		sort.Strings(level)
+	}
+	return levels
+}
+
+// Media is restored one folder level at a time so parents exist before their children
+func (r *restorer) restoreMedia(mediaFiles []string) error {
+	items := r.readItems("media", mediaFiles)
+	count := 0
+	for _, level := range restoreLevels(items) {
+		forEachConcurrent(level, WORKER_COUNT, func(id string) {
+			item := items[id]
+			name, _ := item["name"].(string)
+			oldParent, _ := item["parentId"].(string)
+			newParent := ""
+			if items[oldParent] != nil {
+				r.mu.Lock()
+				newParent = r.mediaKeys[oldParent]
+				r.mu.Unlock()
+				if newParent == "" {
+					r.fail("media", id, name, "parent folder was not restored")
+					return
+				}
+			}
+
+			newId, err := r.createMedia(id, item, newParent)
+			if err != nil {
+				r.fail("media", id, name, err.Error())
+				return
+			}
+			r.mu.Lock()
+			r.mediaKeys[id] = newId
+			count++
+			fmt.Printf("Media: %6d of %6d\r", count, len(items))
+			r.mu.Unlock()
+		})
+	}
+	fmt.Println()
+	return nil
+}
+
+func (r *restorer) createMedia(id string, item map[string]interface{}, parentId string) (string, error) {
+	name, _ := item["name"].(string)
+	if item["mediaTypeAlias"] == "Folder" {
+		var newId string
+		err := retry(4, 200*time.Millisecond, 10*time.Second, func() error {
+			var err error
+			newId, err = createUmbFolder(name, parentId)
+			return err
+		})
+		return newId, err
+	}
+
+	fileName, exists := r.files[id]
+	if !exists {
+		return "", fmt.Errorf("media file missing from snapshot")
+	}
+	data, err := os.ReadFile(filepath.Join(r.dir, filepath.FromSlash(fileName)))
+	if err != nil {
+		return "", err
+	}
+
+	metadata := stripSystemFields(item)
+	for _, alias := range computedMediaProperties {
+		delete(metadata, alias)
+	}
+	delete(metadata, "parentId")
+	if parentId != "" {
+		metadata["parentId"] = parentId
+	}
+	metadata["umbracoFile"] = map[string]string{"src": path.Base(fileName)}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+
+	return retryImage(4, 200*time.Millisecond, 10*time.Second, func() (string, error) {
+		return uploadUmbMedia(metadataJSON, path.Base(fileName), http.DetectContentType(data), bytes.NewReader(data))
+	})
+}
+
+// Content is restored like media, the shows go under parentId and their seasons and episodes under the restored nodes
+func (r *restorer) restoreContent(contentFiles []string, parentId string) {
+	items := r.readItems("content", contentFiles)
+	for _, level := range restoreLevels(items) {
+		forEachConcurrent(level, WORKER_COUNT, func(id string) {
+			item := items[id]
+			newParent := parentId
+			if oldParent, _ := item["parentId"].(string); items[oldParent] != nil {
+				r.mu.Lock()
+				newParent = r.contentIds[oldParent]
+				r.mu.Unlock()
+				if newParent == "" {
+					r.fail("content", id, contentName(item), "parent node was not restored")
+					return
+				}
+			}
+
+			newId, err := r.createContent(id, item, newParent)
+			if err != nil {
+				r.fail("content", id, contentName(item), err.Error())
+				return
+			}
+			r.mu.Lock()
+			r.contentIds[id] = newId
+			r.mu.Unlock()
+		})
+	}
+}
+
+// Creates the node and publishes it if it was published. Missing media and failed publishes are reported, the node is still created
+func (r *restorer) createContent(id string, item map[string]interface{}, parentId string) (string, error) {
+	published := false
+	if states, ok := item["_currentVersionState"].(map[string]interface{}); ok {
+		for _, state := range states {
+			published = published || state == "PUBLISHED"
+		}
+	}
+
+	content := stripSystemFields(item)
+	content["parentId"] = parentId
+	missing := []string{}
+	for alias, value := range content {
+		content[alias] = r.remapValue(value, &missing)
+	}
+	if len(missing) > 0 {
+		r.fail("content", id, contentName(item), "media not restored: "+strings.Join(missing, ", "))
+		// Still restore the node, just without the missing media
+	}
+
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	var body []byte
+	err = retry(4, 200*time.Millisecond, 10*time.Second, func() error {
+		body, err = umbSend("POST", config.UmbBaseURL+"content", contentJSON)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	newId := gjson.GetBytes(body, "_id").String()
+	if published {
+		_, err = umbSend("PUT", fmt.Sprintf("%scontent/%s/publish", config.UmbBaseURL, newId), nil)
+		if err != nil {
+			r.fail("content", id, contentName(item), "restored but not published: "+err.Error())
+		}
+	}
+	return newId, nil
+}
+
+// Swaps media keys for the restored ones and gives block list items new UDIs. Unknown media keys are dropped and added to missing
+func (r *restorer) remapValue(value interface{}, missing *[]string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if _, isBlockList := v["contentData"]; isBlockList {
+			if _, hasLayout := v["layout"]; hasLayout {
+				v = regenerateBlockUdis(v)
+			}
+		}
+		for key, child := range v {
+			v[key] = r.remapValue(child, missing)
+		}
+		return v
+	case []interface{}:
+		result := []interface{}{}
+		for _, child := range v {
+			if picked, ok := child.(map[string]interface{}); ok {
+				if oldKey, ok := picked["mediaKey"].(string); ok {
+					r.mu.Lock()
+					newKey, exists := r.mediaKeys[oldKey]
+					r.mu.Unlock()
+					if !exists {
+						*missing = append(*missing, oldKey)
+						continue
+					}
+					picked["mediaKey"] = newKey
+				}
+			}
+			result = append(result, r.remapValue(child, missing))
+		}
+		return result
+	}
+	return value
+}
+
+// Gives every contentData and settingsData item of a block list a new UDI and updates the layout to match
+func regenerateBlockUdis(blockList map[string]interface{}) map[string]interface{} {
+	udis := make(map[string]string)
+	for _, key := range []string{"contentData", "settingsData"} {
+		items, _ := blockList[key].([]interface{})
+		for _, item := range items {
+			if data, ok := item.(map[string]interface{}); ok {
+				if oldUdi, ok := data["udi"].(string); ok {
+					udis[oldUdi] = fmt.Sprintf("umb://element/%s", generateCustomUUID())
+				}
+			}
+		}
+	}
+
+	var replace func(value interface{}) interface{}
+	replace = func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case string:
+			if newUdi, exists := udis[v]; exists {
+				return newUdi
+			}
+		case map[string]interface{}:
+			for key, child := range v {
+				v[key] = replace(child)
+			}
+		case []interface{}:
+			for i, child := range v {
+				v[i] = replace(child)
+			}
+		}
+		return value
+	}
+	return replace(blockList).(map[string]interface{})
+}
+
+// Drops the read-only fields the API returns (_id, _links, _createDate...)
+func stripSystemFields(item map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, value := range item {
+		if !strings.HasPrefix(key, "_") {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+func contentName(item map[string]interface{}) string {
+	if names, ok := item["name"].(map[string]interface{}); ok {
+		if name, ok := names[LANGUAGE].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// Returns a directory with the snapshot contents. Archives are extracted to a temp directory removed by cleanup
+func openSnapshot(from string) (string, func(), error) {
+	info, err := os.Stat(from)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		return from, func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "restore-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	file, err := os.Open(from)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.FromSlash(path.Clean(header.Name))
+		if strings.HasPrefix(name, "..") || filepath.IsAbs(name) {
+			cleanup()
+			return "", nil, fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		out, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		_, err = io.Copy(out, reader)
+		out.Close()
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+	return dir, cleanup, nil
+}
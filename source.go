@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Where processPage gets its TVMaze shows from
+type MazeSource interface {
+	Page(page int) ([]Show, error)
+	LastPage() int
+}
+
+// Parses the --source flag. "tvmaze" reads from the live API, "dump://<dir>" from a dump made with `tvmaze dump`
+func newMazeSource(spec string) (MazeSource, error) {
+	switch {
+	case spec == "" || spec == "tvmaze":
+		return mazeAPISource{}, nil
+	case strings.HasPrefix(spec, "dump://"):
+		return openDumpSource(strings.TrimPrefix(spec, "dump://"))
+	}
+	return nil, fmt.Errorf("unknown source %q", spec)
+}
+
+type mazeAPISource struct{}
+
+func (mazeAPISource) Page(page int) ([]Show, error) {
+	return getMazePage(config.MazeBaseURL + strconv.Itoa(page))
+}
+
+func (mazeAPISource) LastPage() int {
+	return TOTAL_PAGES
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const STATE_FILE = "state.db"
+
+var stateBucket = []byte("shows")
+var mediaHashBucket = []byte("mediaHashes") // sha256 of the image bytes -> media key
+
+// What the importer remembers about a show between runs
+type ShowState struct {
+	UmbId        string    `json:"umbId"`
+	MediaKey     string    `json:"mediaKey,omitempty"`
+	ImageSource  string    `json:"imageSource,omitempty"` // TVMaze URL of the uploaded image
+	PosterKey    string    `json:"posterKey,omitempty"`
+	PosterSource string    `json:"posterSource,omitempty"`
+	Blurhash     string    `json:"blurhash,omitempty"`
+	Color        string    `json:"color,omitempty"`
+	SourceHash   string    `json:"sourceHash,omitempty"` // showHash of the data last sent to Umbraco
+	LastSync     time.Time `json:"lastSync"`
+
+	// TVMaze's updated time of the show when its episodes, cast and gallery were last synced
+	EpisodesUpdated int64 `json:"episodesUpdated,omitempty"`
+	CastUpdated     int64 `json:"castUpdated,omitempty"`
+	GalleryUpdated  int64 `json:"galleryUpdated,omitempty"`
+	// Kept here because they can't be rebuilt from the show data, a PUT without them would clear showCast and showGallery
+	Cast    []CastMember   `json:"cast,omitempty"`
+	Gallery []GalleryImage `json:"gallery,omitempty"`
+}
+
+// Local bbolt file mapping TVMaze IDs to Umbraco IDs, so a run doesn't have to download every show first
+type StateStore struct {
+	db *bolt.DB
+}
+
+func OpenStateStore(path string) (*StateStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(stateBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(mediaHashBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &StateStore{db: db}, nil
+}
+
+func (s *StateStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *StateStore) Get(id int) (ShowState, bool, error) {
+	state := ShowState{}
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(stateBucket).Get(stateKey(id))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &state)
+	})
+	return state, found, err
+}
+
+// Safe to call from many workers, bbolt batches the writes
+func (s *StateStore) Put(id int, state ShowState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(stateBucket).Put(stateKey(id), data)
+	})
+}
+
+func (s *StateStore) Delete(id int) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(stateBucket).Delete(stateKey(id))
+	})
+}
+
+func (s *StateStore) All() (map[int]ShowState, error) {
+	states := make(map[int]ShowState)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(stateBucket).ForEach(func(key, data []byte) error {
+			state := ShowState{}
+			if err := json.Unmarshal(data, &state); err != nil {
+				return err
+			}
+			states[int(binary.BigEndian.Uint64(key))] = state
+			return nil
+		})
+	})
+	return states, err
+}
+
+func (s *StateStore) Count() int {
+	count := 0
+	s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(stateBucket).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+// Throws away everything in the store and writes states in a single transaction
+func (s *StateStore) Replace(states map[int]ShowState) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(stateBucket); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(stateBucket)
+		if err != nil {
+			return err
+		}
+		for id, state := range states {
+			data, err := json.Marshal(state)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(stateKey(id), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *StateStore) PutMediaHash(hash string, mediaKey string) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(mediaHashBucket).Put([]byte(hash), []byte(mediaKey))
+	})
+}
+
+func (s *StateStore) DeleteMediaHash(hash string) error {
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(mediaHashBucket).Delete([]byte(hash))
+	})
+}
+
+func (s *StateStore) MediaHashes() (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(mediaHashBucket).ForEach(func(hash, mediaKey []byte) error {
+			hashes[string(hash)] = string(mediaKey)
+			return nil
+		})
+	})
+	return hashes, err
+}
+
+func (s *StateStore) ReplaceMediaHashes(hashes map[string]string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(mediaHashBucket); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(mediaHashBucket)
+		if err != nil {
+			return err
+		}
+		for hash, mediaKey := range hashes {
+			if err := bucket.Put([]byte(hash), []byte(mediaKey)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Big endian so the keys sort by show ID
+func stateKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// Hash of the show fields the importer syncs. Equal hashes mean there is nothing to update
+func showHash(show Show) string {
+	// Without --poster TVMaze's poster isn't read and the one in Umbraco is left as it is, so it doesn't count
+	posterSource := ""
+	if config.UploadPoster {
+		posterSource = show.PosterSource
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%s\x00", show.Name, show.Summary, show.ImageSource, posterSource)
+	// In taxonomy mode the block list is cleared and GenreNodes holds the genres
+	if config.GenreMode == "blocks" {
+		for _, genre := range show.Genres {
+			fmt.Fprintf(hash, "%s\x00", genre.Title)
+		}
+	}
+	fmt.Fprintf(hash, "%+v", show.ShowDetails)
+	if config.ImportNetworks {
+		fmt.Fprintf(hash, "\x00%s", show.Network)
+	}
+	if config.GenreMode == "taxonomy" {
+		fmt.Fprintf(hash, "\x00%s", show.GenreNodes)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func runReindex(args []string) {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	statePath := flags.String("state", STATE_FILE, "State store to rebuild")
+	flags.Parse(args)
+
+	store, err := OpenStateStore(*statePath)
+	if err != nil {
+		fmt.Println("Unable to open state store:", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	if !initUmbRoot(&http.Client{}) {
+		return
+	}
+	if _, err := reindexState(store); err != nil {
+		fmt.Println("Reindex failed:", err)
+		os.Exit(1)
+	}
+}
+
+// Rebuilds the state store from the shows and media in Umbraco and returns the shows as an index
+func reindexState(store *StateStore) (*ShowIndex, error) {
+	if err := reindexMediaHashes(store); err != nil {
+		return nil, err
+	}
+	fmt.Println("Beginning umbraco download...")
+	allUmbShows := getAllUmbShows(getUmbShowCount())
+	if allUmbShows == nil {
+		return nil, fmt.Errorf("unable to fetch umb shows")
+	}
+	index := NewShowIndex(allUmbShows)
+	if err := store.Replace(index.States()); err != nil {
+		return nil, err
+	}
+	fmt.Printf("State store rebuilt with %d shows\n", index.Len())
+	return index, nil
+}
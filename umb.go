@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// A non 2xx response from Umbraco
+type umbStatusError struct {
+	Status int
+}
+
+func (e umbStatusError) Error() string {
+	return fmt.Sprintf("status error: %d", e.Status)
+}
+
+// Whether err is an Umbraco response with this status
+func isUmbStatus(err error, status int) bool {
+	var statusErr umbStatusError
+	return errors.As(err, &statusErr) && statusErr.Status == status
+}
+
+// Sends an authenticated GET to Umbraco and returns the body
+func umbGet(url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	setAuthHeader(req)
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, umbStatusError{Status: resp.StatusCode}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Sends an authenticated JSON request to Umbraco and returns the body. Any 2xx status is a success
+func umbSend(method string, url string, jsonData []byte) ([]byte, error) {
+	var body io.Reader
+	if jsonData != nil {
+		body = bytes.NewReader(jsonData)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	setAuthHeader(req)
+	if jsonData != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return respBody, umbStatusError{Status: resp.StatusCode}
+	}
+	return respBody, nil
+}
+
+// Creates a media folder. An empty parentId creates it in the media root
+func createUmbFolder(name string, parentId string) (string, error) {
+	folder := map[string]interface{}{
+		"mediaTypeAlias": "Folder",
+		"name":           name,
+	}
+	if parentId != "" {
+		folder["parentId"] = parentId
+	}
+	folderJSON, err := json.Marshal(folder)
+	if err != nil {
+		return "", err
+	}
+	body, err := umbSend("POST", config.UmbBaseURL+"media", folderJSON)
+	if err != nil {
+		return "", err
+	}
+	return gjson.GetBytes(body, "_id").String(), nil
+}
+
+// Pages through a listing endpoint such as content/{id}/children or media, calling fn for every item in _embedded.<embedded>
+func forEachUmbPage(url string, embedded string, fn func(item gjson.Result) error) error {
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	// Pages in umbraco are 1 indexed
+	for page, totalPages := 1, 1; page <= totalPages; page++ {
+		var body []byte
+		err := retry(8, 200*time.Millisecond, 10*time.Second, func() error {
+			var err error
+			body, err = umbGet(fmt.Sprintf("%s%spage=%d&pageSize=%d", url, separator, page, PAGE_SIZE))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		totalPages = int(gjson.GetBytes(body, "_totalPages").Int())
+		var fnErr error
+		gjson.GetBytes(body, "_embedded."+embedded).ForEach(func(i, item gjson.Result) bool {
+			fnErr = fn(item)
+			return fnErr == nil
+		})
+		if fnErr != nil {
+			return fnErr
+		}
+	}
+	return nil
+}
+
+// Looks for a show node with this showId under the root item. New nodes are sent with sortOrder 0 but may be
+// appended, so the first page is read, then the rest from the last one backwards. With a non-zero since the
+// backward scan stops at a page where every node is older, the show can't be on the pages before it
+func findUmbShow(showId int, since time.Time) (Show, bool, error) {
+	pageUrl := func(page int) string {
+		return fmt.Sprintf("%s/children?page=%d&pageSize=%d", config.UmbRootItemURL, page, PAGE_SIZE)
+	}
+	query := fmt.Sprintf("_embedded.content.#(showId.$invariant==%d)", showId)
+
+	firstPage, err := umbGet(pageUrl(1))
+	if err != nil {
+		return Show{}, false, err
+	}
+	if node := gjson.GetBytes(firstPage, query); node.Exists() {
+		return parseUmbShow(node), true, nil
+	}
+	for page := int(gjson.GetBytes(firstPage, "_totalPages").Int()); page > 1; page-- {
+		body, err := umbGet(pageUrl(page))
+		if err != nil {
+			return Show{}, false, err
+		}
+		if node := gjson.GetBytes(body, query); node.Exists() {
+			return parseUmbShow(node), true, nil
+		}
+		if !since.IsZero() && createdBefore(gjson.GetBytes(body, "_embedded.content").Array(), since) {
+			break
+		}
+	}
+	return Show{}, false, nil
+}
+
+// Whether every node was created before t. Nodes without a readable _createDate count as newer
+func createdBefore(nodes []gjson.Result, t time.Time) bool {
+	for _, node := range nodes {
+		created, err := parseUmbDate(node.Get("_createDate").String())
+		if err != nil || !created.Before(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether a request that failed with err may still have been carried out by Umbraco: it timed out, the connection
+// failed or the server errored. Other status errors mean the request was refused
+func umbMayHaveApplied(err error) bool {
+	var statusErr umbStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status >= 500
+	}
+	return true
+}
+
+// Downloads every child of the root item as raw JSON
+func getAllUmbContent() ([]gjson.Result, error) {
+	defer timeTrack(time.Now(), "Download all umb content")
+	items := []gjson.Result{}
+	err := forEachUmbPage(config.UmbRootItemURL+"/children", "content", func(item gjson.Result) error {
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// Content types with nodes below them: seasons below shows and episodes below seasons
+var contentWithChildren = map[string]bool{"tVShow": true, "season": true}
+
+// Calls fn for every child of the root item and the nodes below them, parents before their children
+func forEachUmbContent(fn func(item gjson.Result) error) error {
+	return forEachUmbContentIn(config.UmbRootItemURL+"/children", fn)
+}
+
+func forEachUmbContentIn(url string, fn func(item gjson.Result) error) error {
+	return forEachUmbPage(url, "content", func(item gjson.Result) error {
+		if err := fn(item); err != nil {
+			return err
+		}
+		if contentWithChildren[item.Get("contentTypeAlias").String()] {
+			return forEachUmbContentIn(fmt.Sprintf("%scontent/%s/children", config.UmbBaseURL, item.Get("_id").String()), fn)
+		}
+		return nil
+	})
+}
+
+// Downloads every media item, including folders and their contents, as raw JSON
+func getAllUmbMedia() ([]gjson.Result, error) {
+	defer timeTrack(time.Now(), "Download all umb media")
+	items := []gjson.Result{}
+	err := forEachUmbMedia(func(item gjson.Result) error {
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// Calls fn for every media item, descending into folders
+func forEachUmbMedia(fn func(item gjson.Result) error) error {
+	return forEachUmbMediaIn(config.UmbBaseURL+"media", fn)
+}
+
+func forEachUmbMediaIn(url string, fn func(item gjson.Result) error) error {
+	return forEachUmbPage(url, "media", func(item gjson.Result) error {
+		if err := fn(item); err != nil {
+			return err
+		}
+		if item.Get("mediaTypeAlias").String() == "Folder" {
+			return forEachUmbMediaIn(fmt.Sprintf("%smedia/%s/children", config.UmbBaseURL, item.Get("_id").String()), fn)
+		}
+		return nil
+	})
+}
+
+// The umbracoFile src of a media item can be relative to the media CDN
+func umbMediaFileURL(src string) string {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return src
+	}
+	return "https://media.umbraco.io/" + UMB_PROJ_ALIAS + "/" + strings.TrimPrefix(src, "/")
+}
+
+// Umbraco dates may or may not carry a time zone. Dates without one are UTC
+func parseUmbDate(value string) (time.Time, error) {
+	date, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Parse("2006-01-02T15:04:05.999999999", value)
+	}
+	return date, nil
+}
+
+// UDI of a content node, as stored by content pickers
+func umbDocumentUdi(id string) string {
+	return "umb://document/" + strings.ReplaceAll(id, "-", "")
+}
+
+// POSTs a content node and returns its _id
+func createUmbContent(node map[string]interface{}) (string, error) {
+	nodeJSON, err := json.Marshal(node)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = retry(4, 200*time.Millisecond, 10*time.Second, func() error {
+		body, err := umbSend("POST", config.UmbBaseURL+"content", nodeJSON)
+		if err != nil {
+			return err
+		}
+		id = gjson.GetBytes(body, "_id").String()
+		return nil
+	})
+	return id, err
+}
+
+// PUTs a content node. node needs its parentId, like the show PUTs
+func updateUmbContent(id string, node map[string]interface{}) error {
+	nodeJSON, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	return retry(4, 200*time.Millisecond, 10*time.Second, func() error {
+		_, err := umbSend("PUT", config.UmbBaseURL+"content/"+id, nodeJSON)
+		return err
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import "sync"
+
+// Calls fn for every item using the given number of goroutines and waits for all of them to finish
+func forEachConcurrent[T any](items []T, workers int, fn func(item T)) {
+	var wg sync.WaitGroup
+	itemChan := make(chan T)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range itemChan {
+				fn(item)
+			}
+		}()
+	}
+	for _, item := range items {
+		itemChan <- item
+	}
+	close(itemChan)
+	wg.Wait()
+}
+
+// Mutex per key. Locking one key doesn't block the others
+type keyedMutex[K comparable] struct {
+	mu    sync.Mutex
+	locks map[K]*keyedLock
+}
+
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Locks key until the returned func is called
+func (k *keyedMutex[K]) Lock(key K) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[K]*keyedLock)
+	}
+	lock, exists := k.locks[key]
+	if !exists {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		k.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}